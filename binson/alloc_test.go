@@ -0,0 +1,320 @@
+// Checks that the Encoder, the Decoder and the functions that work
+// on buffers do not allocate memory.
+// Note, like the examples, these tests are not executed with
+// "tinygo test", TinyGo has no testing.AllocsPerRun.
+
+package binson
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// {"a":true,"b":-200,"c":1.5,"d":"str","e":0x0102,
+// "f":[1,{"g":false},[]],"h":{"i":70000}}
+var allocBuf = []byte("\x40\x14\x01\x61\x44\x14\x01\x62\x11\x38\xff\x14\x01\x63" +
+	"\x46\x00\x00\x00\x00\x00\x00\xf8\x3f\x14\x01\x64\x14\x03\x73\x74\x72" +
+	"\x14\x01\x65\x18\x02\x01\x02\x14\x01\x66\x42\x10\x01\x40\x14\x01\x67\x45\x41" +
+	"\x42\x43\x43\x14\x01\x68\x40\x14\x01\x69\x12\x70\x11\x01\x00\x41\x41")
+
+var allocOut = make([]byte, 1000)
+
+var allocName = []byte("name")
+
+var allocBytes = []byte("\x01\x02")
+
+// {"a":"\xff"}
+var allocInvalidUTF8 = []byte("\x40\x14\x01\x61\x14\x01\xff\x41")
+
+// Each method of the Encoder, called after Init and Begin.
+var encoderAllocTable = []struct {
+	method string
+	f      func(e *Encoder)
+}{
+	{"Init", func(e *Encoder) { e.Init(allocOut) }},
+	{"Begin", func(e *Encoder) { e.Begin() }},
+	{"End", func(e *Encoder) { e.End() }},
+	{"BeginArray", func(e *Encoder) { e.BeginArray() }},
+	{"EndArray", func(e *Encoder) { e.EndArray() }},
+	{"Bool", func(e *Encoder) { e.Bool(true) }},
+	{"Integer", func(e *Encoder) { e.Integer(-70000) }},
+	{"Uint64", func(e *Encoder) { e.Uint64(1 << 63) }},
+	{"Double", func(e *Encoder) { e.Double(1.5) }},
+	{"Float32", func(e *Encoder) { e.Float32(1.5) }},
+	{"String", func(e *Encoder) { e.String("str") }},
+	{"Bytes", func(e *Encoder) { e.Bytes(allocName) }},
+	{"Name", func(e *Encoder) { e.Name("name") }},
+	{"NameBytes", func(e *Encoder) { e.NameBytes(allocName) }},
+	{"FieldBool", func(e *Encoder) { e.FieldBool("name", true) }},
+	{"FieldInt", func(e *Encoder) { e.FieldInt("name", 1) }},
+	{"FieldDouble", func(e *Encoder) { e.FieldDouble("name", 1.5) }},
+	{"FieldString", func(e *Encoder) { e.FieldString("name", "str") }},
+	{"FieldBytes", func(e *Encoder) { e.FieldBytes("name", allocName) }},
+	{"FieldBeginObject", func(e *Encoder) { e.FieldBeginObject("name") }},
+	{"FieldBeginArray", func(e *Encoder) { e.FieldBeginArray("name") }},
+	{"FieldBoolB", func(e *Encoder) { e.FieldBoolB(allocName, true) }},
+	{"FieldIntB", func(e *Encoder) { e.FieldIntB(allocName, 1) }},
+	{"FieldDoubleB", func(e *Encoder) { e.FieldDoubleB(allocName, 1.5) }},
+	{"FieldStringB", func(e *Encoder) { e.FieldStringB(allocName, "str") }},
+	{"FieldBytesB", func(e *Encoder) { e.FieldBytesB(allocName, allocName) }},
+	{"FieldBeginObjectB", func(e *Encoder) { e.FieldBeginObjectB(allocName) }},
+	{"FieldBeginArrayB", func(e *Encoder) { e.FieldBeginArrayB(allocName) }},
+}
+
+// Each method of the Decoder, called after Init with allocBuf and
+// the navigation needed to make the call valid.
+var decoderAllocTable = []struct {
+	method string
+	f      func(d *Decoder)
+}{
+	{"Init", func(d *Decoder) {}},
+	{"Field", func(d *Decoder) { d.Field("h") }},
+	{"NextField", func(d *Decoder) { d.NextField() }},
+	{"NextArrayValue", func(d *Decoder) {
+		d.Field("f")
+		d.GoIntoArray()
+		d.NextArrayValue()
+	}},
+	{"GoIntoObject", func(d *Decoder) {
+		d.Field("h")
+		d.GoIntoObject()
+	}},
+	{"GoIntoArray", func(d *Decoder) {
+		d.Field("f")
+		d.GoIntoArray()
+	}},
+	{"GoUpToObject", func(d *Decoder) {
+		d.Field("f")
+		d.GoIntoArray()
+		d.GoUpToObject()
+	}},
+	{"GoUpToArray", func(d *Decoder) {
+		d.Field("f")
+		d.GoIntoArray()
+		d.NextArrayValue()
+		d.NextArrayValue()
+		d.GoIntoObject()
+		d.GoUpToArray()
+	}},
+	{"Offset", func(d *Decoder) { d.Offset() }},
+	{"Consumed", func(d *Decoder) { d.Consumed() }},
+	{"Int8", func(d *Decoder) { firstInF(d); d.Int8() }},
+	{"Int16", func(d *Decoder) { firstInF(d); d.Int16() }},
+	{"Int32", func(d *Decoder) { firstInF(d); d.Int32() }},
+	{"Uint8", func(d *Decoder) { firstInF(d); d.Uint8() }},
+	{"Uint16", func(d *Decoder) { firstInF(d); d.Uint16() }},
+	{"Uint32", func(d *Decoder) { firstInF(d); d.Uint32() }},
+	{"Uint64", func(d *Decoder) { firstInF(d); d.Uint64() }},
+	{"Float32", func(d *Decoder) { d.Field("c"); d.Float32() }},
+}
+
+// Reads the first value of array "f" of allocBuf, the integer 1.
+func firstInF(d *Decoder) {
+	d.Field("f")
+	d.GoIntoArray()
+	d.NextArrayValue()
+}
+
+// Each method of the ObjectIterator.
+var iteratorAllocTable = []struct {
+	method string
+	f      func(it *ObjectIterator)
+}{
+	{"Next", func(it *ObjectIterator) { it.Next() }},
+	{"Object", func(it *ObjectIterator) { it.Next(); it.Object() }},
+	{"Offset", func(it *ObjectIterator) { it.Next(); it.Offset() }},
+}
+
+var allocHash = sha256.New()
+
+// Typical uses, the package functions, and errors.
+var sequenceAllocTable = []struct {
+	name string
+	f    func()
+}{
+	{"walk", func() {
+		d := Decoder{Strict: true}
+		d.Init(allocBuf)
+		walkAllocBuf(&d)
+	}},
+	{"encode", func() {
+		e := Encoder{}
+		e.Init(allocOut)
+		encodeAllocBuf(&e)
+	}},
+	{"Validate", func() { Validate(allocBuf) }},
+	{"IsObject", func() { IsObject(allocBuf) }},
+	{"Canonicalize", func() { Canonicalize(allocOut, allocBuf) }},
+	{"Merge", func() { Merge(allocOut, allocBuf, allocBuf) }},
+	{"HashScratch", func() {
+		allocHash.Reset()
+		HashScratch(allocBuf, allocOut, allocHash)
+	}},
+	{"Objects", func() {
+		it := Objects(allocBuf)
+		for it.Next() {
+		}
+	}},
+	{"truncated input", func() {
+		d := Decoder{}
+		d.Init(allocBuf[:len(allocBuf)-10])
+		walkAllocBuf(&d)
+	}},
+	{"invalid UTF-8", func() {
+		d := Decoder{Strict: true}
+		d.Init(allocInvalidUTF8)
+		d.Field("a")
+	}},
+	{"encoder buffer too small", func() {
+		e := Encoder{}
+		e.Init(allocOut[:10])
+		encodeAllocBuf(&e)
+	}},
+	{"encoder invalid UTF-8", func() {
+		e := Encoder{}
+		e.Init(allocOut)
+		e.String("\xff")
+	}},
+}
+
+// Reads all values of allocBuf, like an application would.
+func walkAllocBuf(d *Decoder) {
+	d.Field("a")
+	d.Field("b")
+	d.Int16()
+	d.Field("c")
+	d.Field("d")
+	d.Field("e")
+	d.Field("f")
+	d.GoIntoArray()
+	for d.NextArrayValue() {
+		if d.ValueType == Object {
+			d.GoIntoObject()
+			d.Field("g")
+			d.GoUpToArray()
+		}
+	}
+	d.GoUpToObject()
+	d.Field("h")
+	d.GoIntoObject()
+	d.Field("i")
+	d.Int32()
+	d.GoUpToObject()
+	d.NextField()
+}
+
+// Writes allocBuf.
+func encodeAllocBuf(e *Encoder) {
+	e.Begin()
+	e.FieldBool("a", true)
+	e.FieldInt("b", -200)
+	e.FieldDouble("c", 1.5)
+	e.FieldString("d", "str")
+	e.FieldBytes("e", allocBytes)
+	e.FieldBeginArray("f")
+	e.Integer(1)
+	e.Begin()
+	e.FieldBool("g", false)
+	e.End()
+	e.BeginArray()
+	e.EndArray()
+	e.EndArray()
+	e.FieldBeginObject("h")
+	e.FieldInt("i", 70000)
+	e.End()
+	e.End()
+}
+
+func TestZeroAllocs(t *testing.T) {
+	assertNoAllocs := func(name string, f func()) {
+		if allocs := testing.AllocsPerRun(100, f); allocs != 0 {
+			t.Errorf("%s: %v allocations", name, allocs)
+		}
+	}
+
+	e := Encoder{}
+	for _, record := range encoderAllocTable {
+		assertNoAllocs("Encoder."+record.method, func() {
+			e.Init(allocOut)
+			e.Begin()
+			record.f(&e)
+		})
+	}
+
+	d := Decoder{}
+	for _, record := range decoderAllocTable {
+		assertNoAllocs("Decoder."+record.method, func() {
+			d.Init(allocBuf)
+			record.f(&d)
+		})
+		if d.Error != ErrorNone {
+			t.Errorf("Decoder.%s: error %d", record.method, d.Error)
+		}
+	}
+
+	it := ObjectIterator{}
+	for _, record := range iteratorAllocTable {
+		assertNoAllocs("ObjectIterator."+record.method, func() {
+			it = Objects(allocBuf)
+			record.f(&it)
+		})
+	}
+
+	for _, record := range sequenceAllocTable {
+		assertNoAllocs(record.name, record.f)
+	}
+}
+
+// Checks that the tables above have all exported methods, so that new
+// methods are not forgotten.
+func TestZeroAllocsCoversAllMethods(t *testing.T) {
+	check := func(v interface{}, names []string) {
+		sort.Strings(names)
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumMethod(); i++ {
+			name := typ.Method(i).Name
+			if j := sort.SearchStrings(names, name); j == len(names) || names[j] != name {
+				t.Errorf("%s.%s is not in the allocation tests", typ.Elem().Name(), name)
+			}
+		}
+	}
+
+	var names []string
+	for _, record := range encoderAllocTable {
+		names = append(names, record.method)
+	}
+	check(&Encoder{}, names)
+
+	names = nil
+	for _, record := range decoderAllocTable {
+		names = append(names, record.method)
+	}
+	check(&Decoder{}, names)
+
+	names = nil
+	for _, record := range iteratorAllocTable {
+		names = append(names, record.method)
+	}
+	check(&ObjectIterator{}, names)
+}
+
+func TestAllocBufEncoding(t *testing.T) {
+	e := Encoder{}
+	e.Init(allocOut)
+	encodeAllocBuf(&e)
+	assertEqualString(t, string(allocBuf), string(allocOut[:e.Offset]))
+	assertEqualInt64(t, ErrorNone, int64(Validate(allocBuf)))
+}
+
+func BenchmarkSequences(b *testing.B) {
+	for _, record := range sequenceAllocTable {
+		b.Run(record.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				record.f()
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+// Benchmarks with representative message shapes, and encoding/json
+// baselines on equivalent data. Run with, for example:
+//
+//	go test -run XXX -bench . ./binson
+//	go test -run XXX -bench 'Decode/flat' ./binson
+//
+// Each shape is a sub-benchmark of BenchmarkEncode, BenchmarkDecode,
+// BenchmarkJSONEncode and BenchmarkJSONDecode. The byte rates are for
+// the Binson and JSON sizes respectively. The JSON benchmarks allocate,
+// and use reflection, they are baselines for standard Go only.
+
+package binson
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const (
+	benchDepth     = 50    // nesting of the "deep" shape
+	benchArrayLen  = 1000  // values in the arrays of "array" and "numbers"
+	benchLongLen   = 65536 // bytes in the strings of the "long" shape
+	benchFieldsLen = 200   // fields in the "late field" shape
+)
+
+var (
+	benchOut       = make([]byte, 1<<20)
+	benchLongBytes = bytes.Repeat([]byte("x"), benchLongLen)
+	benchLong      = string(benchLongBytes)
+	benchNames     = benchFieldNames()
+)
+
+// f000, f001, ... sorted field names of the "late field" shape.
+func benchFieldNames() []string {
+	names := make([]string, benchFieldsLen)
+	for i := range names {
+		names[i] = "f" + strconv.Itoa(1000 + i)[1:]
+	}
+	return names
+}
+
+// JSON equivalents of the shapes.
+
+type benchFlat struct {
+	ID   int64   `json:"id"`
+	Name string  `json:"name"`
+	OK   bool    `json:"ok"`
+	Temp float64 `json:"temp"`
+}
+
+type benchDeep struct {
+	A *benchDeep `json:"a,omitempty"`
+	V int64      `json:"v"`
+}
+
+type benchNumbers struct {
+	D []float64 `json:"d"`
+	I []int64   `json:"i"`
+}
+
+type benchLongValues struct {
+	B []byte `json:"b"`
+	S string `json:"s"`
+}
+
+var benchShapes = []struct {
+	name   string
+	encode func(e *Encoder)
+	decode func(d *Decoder) // reads all values, or the one looked up
+	json   func() interface{}
+}{
+	{"flat", func(e *Encoder) {
+		// {"id":123,"name":"sensor","ok":true,"temp":21.5}
+		e.Begin()
+		e.FieldInt("id", 123)
+		e.FieldString("name", "sensor")
+		e.FieldBool("ok", true)
+		e.FieldDouble("temp", 21.5)
+		e.End()
+	}, func(d *Decoder) {
+		d.Field("id")
+		d.Field("name")
+		d.Field("ok")
+		d.Field("temp")
+	}, func() interface{} {
+		return &benchFlat{ID: 123, Name: "sensor", OK: true, Temp: 21.5}
+	}},
+
+	{"deep", func(e *Encoder) {
+		// {"a":{"a":{..."v":0}..."v":0},"v":0}
+		e.Begin()
+		for i := 1; i < benchDepth; i++ {
+			e.FieldBeginObject("a")
+		}
+		for i := 0; i < benchDepth; i++ {
+			e.FieldInt("v", 0)
+			e.End()
+		}
+	}, func(d *Decoder) {
+		for i := 1; i < benchDepth; i++ {
+			d.Field("a")
+			d.GoIntoObject()
+		}
+		for i := 1; i < benchDepth; i++ {
+			d.Field("v")
+			d.GoUpToObject()
+		}
+		d.Field("v")
+	}, func() interface{} {
+		v := &benchDeep{}
+		for i := 1; i < benchDepth; i++ {
+			v = &benchDeep{A: v}
+		}
+		return v
+	}},
+
+	{"array", func(e *Encoder) {
+		// {"a":[0,1,...]}
+		e.Begin()
+		e.FieldBeginArray("a")
+		for i := 0; i < benchArrayLen; i++ {
+			e.Integer(int64(i))
+		}
+		e.EndArray()
+		e.End()
+	}, func(d *Decoder) {
+		d.Field("a")
+		d.GoIntoArray()
+		for d.NextArrayValue() {
+		}
+	}, func() interface{} {
+		a := make([]int64, benchArrayLen)
+		for i := range a {
+			a[i] = int64(i)
+		}
+		return &a
+	}},
+
+	{"numbers", func(e *Encoder) {
+		// {"d":[0.5,1.5,...],"i":[4294967296,4294967297,...]}, doubles and
+		// 8-byte integers, to measure the integer conversions.
+		e.Begin()
+		e.FieldBeginArray("d")
+		for i := 0; i < benchArrayLen; i++ {
+			e.Double(float64(i) + 0.5)
+		}
+		e.EndArray()
+		e.FieldBeginArray("i")
+		for i := 0; i < benchArrayLen; i++ {
+			e.Integer(1<<32 + int64(i))
+		}
+		e.EndArray()
+		e.End()
+	}, func(d *Decoder) {
+		d.Field("d")
+		d.GoIntoArray()
+		for d.NextArrayValue() {
+		}
+		d.GoUpToObject()
+		d.Field("i")
+		d.GoIntoArray()
+		for d.NextArrayValue() {
+		}
+	}, func() interface{} {
+		v := &benchNumbers{D: make([]float64, benchArrayLen), I: make([]int64, benchArrayLen)}
+		for i := range v.D {
+			v.D[i] = float64(i) + 0.5
+			v.I[i] = 1<<32 + int64(i)
+		}
+		return v
+	}},
+
+	{"long", func(e *Encoder) {
+		// {"b":0x7878...,"s":"xx..."}
+		e.Begin()
+		e.FieldBytes("b", benchLongBytes)
+		e.FieldString("s", benchLong)
+		e.End()
+	}, func(d *Decoder) {
+		// The values are slices of the input, so the rate is high.
+		d.Field("b")
+		d.Field("s")
+	}, func() interface{} {
+		return &benchLongValues{B: benchLongBytes, S: benchLong}
+	}},
+
+	{"late field", func(e *Encoder) {
+		// {"f000":0,"f001":1,...}
+		e.Begin()
+		for i, name := range benchNames {
+			e.FieldInt(name, int64(i))
+		}
+		e.End()
+	}, func(d *Decoder) {
+		d.Field(benchNames[benchFieldsLen-1])
+	}, func() interface{} {
+		m := map[string]int64{}
+		for i, name := range benchNames {
+			m[name] = int64(i)
+		}
+		return &m
+	}},
+}
+
+// Returns the Binson encoding of shape i.
+func benchEncode(i int) []byte {
+	e := Encoder{}
+	e.Init(benchOut)
+	benchShapes[i].encode(&e)
+	if e.Error != ErrorNone {
+		panic("benchmark buffer too small")
+	}
+	return benchOut[:e.Offset]
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for i, shape := range benchShapes {
+		b.Run(shape.name, func(b *testing.B) {
+			b.SetBytes(int64(len(benchEncode(i))))
+			b.ReportAllocs()
+			e := Encoder{}
+			for n := 0; n < b.N; n++ {
+				e.Init(benchOut)
+				shape.encode(&e)
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for i, shape := range benchShapes {
+		buf := append([]byte(nil), benchEncode(i)...)
+		b.Run(shape.name, func(b *testing.B) {
+			b.SetBytes(int64(len(buf)))
+			b.ReportAllocs()
+			d := Decoder{}
+			for n := 0; n < b.N; n++ {
+				d.Init(buf)
+				shape.decode(&d)
+			}
+			if d.Error != ErrorNone {
+				b.Fatalf("error %d", d.Error)
+			}
+		})
+	}
+}
+
+func BenchmarkJSONEncode(b *testing.B) {
+	for _, shape := range benchShapes {
+		v := shape.json()
+		data, err := json.Marshal(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(shape.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				json.Marshal(v)
+			}
+		})
+	}
+}
+
+func BenchmarkJSONDecode(b *testing.B) {
+	for _, shape := range benchShapes {
+		v := shape.json()
+		data, err := json.Marshal(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		typ := reflect.TypeOf(v).Elem()
+		b.Run(shape.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				// A new zero value each time, like the Decoder starts anew.
+				if err := json.Unmarshal(data, reflect.New(typ).Interface()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// The benchmarks are not checked by "go test", this checks that the
+// shapes are valid and read to the end.
+func TestBenchShapes(t *testing.T) {
+	for i, shape := range benchShapes {
+		buf := benchEncode(i)
+		if err := Validate(buf); err != ErrorNone {
+			t.Errorf("%s: Validate error %d", shape.name, err)
+		}
+		d := Decoder{}
+		d.Init(buf)
+		shape.decode(&d)
+		if d.Error != ErrorNone {
+			t.Errorf("%s: decode error %d", shape.name, d.Error)
+		}
+	}
+}
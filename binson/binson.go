@@ -2,6 +2,7 @@
 package binson
 
 import (
+	"hash"
 	"unsafe"
 )
 
@@ -48,6 +49,13 @@ const eightBytes byte = 0x03
 const twoTo7 int64 = 128
 const twoTo15 int64 = 32768
 const twoTo31 int64 = 2147483648
+const maxInt64 uint64 = 1<<63 - 1
+
+// Max nesting of objects and arrays accepted by Validate.
+const maxDepth = 100
+
+// Max fields of an unsorted object accepted by Canonicalize.
+const maxUnsortedFields = 256
 
 // Binson Decoder private constants
 const (
@@ -80,6 +88,16 @@ const ErrorNegativeLength = 11
 const ErrorLengthTooLarge = 12
 const ErrorExpectedBegin = 13
 const ErrorNameTooLarge = 14
+const ErrorOutOfRange = 15
+const ErrorInexactFloat32 = 16
+const ErrorInvalidUTF8 = 17
+const ErrorNotShortest = 18
+const ErrorFieldOrder = 19
+const ErrorDuplicateName = 20
+const ErrorTooDeep = 21
+const ErrorTrailingBytes = 22
+const ErrorMissingField = 23
+const ErrorTooManyFields = 24
 
 // ======== Decoder ========
 
@@ -100,11 +118,30 @@ const ErrorNameTooLarge = 14
 //
 // The Decoder struct can be reused for parsing more Binson objects as
 // long as Init() is called before parsing each object.
+//
+// Setting Strict to true enables two additional checks: field names and
+// string values must be valid UTF-8, or d.Error is set to
+// ErrorInvalidUTF8, and Float32 sets d.Error to ErrorInexactFloat32 for
+// doubles that cannot be represented exactly as a float32. Strict does
+// not check that integers and lengths use their shortest encoding, nor
+// the order of fields; Validate checks those.
+//
+// MaxLength limits the length of strings, bytes values and field names,
+// for example to reject large values early when the input is read
+// from a network. Longer values give ErrorLengthTooLarge. The default,
+// 0, allows the max length of Binson, 2^31-1 bytes.
+// Settings are not changed by Init().
 type Decoder struct {
-	buf     []byte // input buffer
-	offset  int    // offset to next byte to reade
-	state   int
-	sigByte byte
+	buf         []byte // input buffer
+	offset      int    // offset to next byte to reade
+	state       int
+	sigByte     byte
+	valueOffset int  // offset of the last read field value
+	depth       int  // number of objects and arrays begun but not ended
+	shortest    bool // integers and lengths must be shortest, set by Validate
+
+	Strict    bool // strict mode, see the Decoder doc
+	MaxLength int  // max length of strings and bytes, see the Decoder doc
 
 	Error        int
 	Name         []byte
@@ -113,6 +150,10 @@ type Decoder struct {
 	ValueInteger int64
 	ValueDouble  float64
 	ValueBytes   []byte
+
+	// ErrorOffset is the offset in the input buffer of the first
+	// invalid byte when Error is ErrorInvalidUTF8.
+	ErrorOffset int
 }
 
 // Initializes the decoder which prepares it to read from buf.
@@ -120,6 +161,7 @@ type Decoder struct {
 func (d *Decoder) Init(buf []byte) {
 	d.buf = buf
 	d.offset = 0
+	d.depth = 0
 	d.state = stateZero
 	d.sigByte = sigBegin
 	d.Error = ErrorNone
@@ -130,6 +172,7 @@ func (d *Decoder) Init(buf []byte) {
 	d.ValueDouble = 0.0
 	d.ValueBytes = nil
 	d.Name = nil
+	d.ErrorOffset = 0
 }
 
 // Parses until a field with the given name has been parsed.
@@ -159,6 +202,9 @@ func (d *Decoder) NextField() bool {
 	switch d.state {
 	case stateZero:
 		d.parseBegin()
+		if d.Error != ErrorNone {
+			return false
+		}
 	case stateEndOfObject:
 		d.Error = ErrorEndOfObject
 		return false
@@ -169,6 +215,9 @@ func (d *Decoder) NextField() bool {
 				return false
 			}
 		}
+		if d.Error != ErrorNone {
+			return false
+		}
 		d.state = stateBeforeField
 	case stateBeforeArray:
 		d.state = stateBeforeArrayValue
@@ -177,6 +226,9 @@ func (d *Decoder) NextField() bool {
 				return false
 			}
 		}
+		if d.Error != ErrorNone {
+			return false
+		}
 		d.state = stateBeforeField
 	}
 
@@ -191,10 +243,15 @@ func (d *Decoder) NextField() bool {
 	}
 	if typeBeforeName == sigEnd {
 		d.state = stateEndOfObject
+		d.depth--
 		return false
 	}
 	d.parseName(typeBeforeName)
+	if d.Error != ErrorNone {
+		return false
+	}
 
+	d.valueOffset = d.offset
 	typeBeforeValue := d.readOne()
 	if d.Error != ErrorNone {
 		return false
@@ -216,6 +273,9 @@ func (d *Decoder) NextArrayValue() bool {
 				return false
 			}
 		}
+		if d.Error != ErrorNone {
+			return false
+		}
 		d.state = stateBeforeArrayValue
 	}
 
@@ -226,6 +286,9 @@ func (d *Decoder) NextArrayValue() bool {
 				return false
 			}
 		}
+		if d.Error != ErrorNone {
+			return false
+		}
 		d.state = stateBeforeArrayValue
 	}
 
@@ -240,6 +303,7 @@ func (d *Decoder) NextArrayValue() bool {
 	}
 	if sig == sigEndArray {
 		d.state = stateEndOfArray
+		d.depth--
 		return false
 	}
 	d.parseValue(sig, stateBeforeArrayValue)
@@ -273,6 +337,9 @@ func (d *Decoder) GoUpToObject() {
 				return
 			}
 		}
+		if d.Error != ErrorNone {
+			return
+		}
 	}
 
 	if d.state == stateBeforeField {
@@ -281,6 +348,9 @@ func (d *Decoder) GoUpToObject() {
 				return
 			}
 		}
+		if d.Error != ErrorNone {
+			return
+		}
 	}
 
 	if d.state != stateEndOfObject && d.state != stateEndOfArray {
@@ -299,6 +369,9 @@ func (d *Decoder) GoUpToArray() {
 				return
 			}
 		}
+		if d.Error != ErrorNone {
+			return
+		}
 	}
 
 	if d.state == stateBeforeField {
@@ -307,6 +380,9 @@ func (d *Decoder) GoUpToArray() {
 				return
 			}
 		}
+		if d.Error != ErrorNone {
+			return
+		}
 	}
 
 	if d.state != stateEndOfObject && d.state != stateEndOfArray {
@@ -317,16 +393,188 @@ func (d *Decoder) GoUpToArray() {
 	d.state = stateBeforeArrayValue
 }
 
+// Offset returns the offset in the input buffer of the next byte
+// to read.
+func (d *Decoder) Offset() int {
+	return d.offset
+}
+
+// Consumed returns the size in bytes of the top-level object. The
+// object is read again from its start with NextField, which checks
+// that objects have fields with string names, and that each object
+// and array ends with its own end byte. The decoder is left at the
+// end of the object.
+// Bytes after the object are not read, so for input with several
+// concatenated objects, Consumed gives the offset of the next object.
+// Returns 0 if d.Error is set.
+func (d *Decoder) Consumed() int {
+	if d.Error != ErrorNone {
+		return 0
+	}
+	d.Init(d.buf)
+	for d.NextField() {
+		if d.Error != ErrorNone {
+			return 0
+		}
+	}
+	if d.Error != ErrorNone {
+		return 0
+	}
+	return d.offset
+}
+
+// ======== Objects ========
+
+// An ObjectIterator iterates over Binson objects stored back-to-back
+// in a buffer, like this:
+//
+//	it := Objects(buf)
+//	for it.Next() {
+//		obj := it.Object()
+//		...
+//	}
+//	if it.Error != ErrorNone {
+//		...
+//	}
+type ObjectIterator struct {
+	buf    []byte
+	offset int
+	obj    []byte
+	d      Decoder
+
+	Error int // error code (ErrorX) of the object that ended the iteration
+}
+
+// Objects returns an iterator over the top-level objects in buf.
+func Objects(buf []byte) ObjectIterator {
+	return ObjectIterator{buf: buf}
+}
+
+// Next moves to the next object. Returns false at the end of the buffer
+// or if the next object is invalid, in which case it.Error is set.
+func (it *ObjectIterator) Next() bool {
+	it.obj = nil
+	if it.Error != ErrorNone || it.offset >= len(it.buf) {
+		return false
+	}
+
+	it.d.Init(it.buf[it.offset:])
+	n := it.d.Consumed()
+	if it.d.Error != ErrorNone {
+		it.Error = it.d.Error
+		return false
+	}
+
+	it.obj = it.buf[it.offset : it.offset+n]
+	it.offset += n
+	return true
+}
+
+// Object returns the current object, a sub-slice of the buffer.
+func (it *ObjectIterator) Object() []byte {
+	return it.obj
+}
+
+// Offset returns the offset in the buffer after the current object.
+func (it *ObjectIterator) Offset() int {
+	return it.offset
+}
+
+// IsObject returns true if buf is exactly one Binson object. The
+// structure is checked like by Consumed, but unlike with Validate, the
+// object does not have to be in canonical form.
+func IsObject(buf []byte) bool {
+	d := Decoder{}
+	d.Init(buf)
+	return d.Consumed() == len(buf) && d.Error == ErrorNone
+}
+
+// ======== Decoder, integer accessors ========
+// The accessors return the last read integer value converted to a
+// narrower or unsigned type. If the value is not an integer,
+// d.Error is set to ErrorUnexpectedType. If the value does not fit in
+// the type, d.Error is set to ErrorOutOfRange. In both cases
+// 0 is returned.
+
+// Int8 returns the integer value as an int8.
+func (d *Decoder) Int8() int8 {
+	return int8(d.integerInRange(-twoTo7, twoTo7-1))
+}
+
+// Int16 returns the integer value as an int16.
+func (d *Decoder) Int16() int16 {
+	return int16(d.integerInRange(-twoTo15, twoTo15-1))
+}
+
+// Int32 returns the integer value as an int32.
+func (d *Decoder) Int32() int32 {
+	return int32(d.integerInRange(-twoTo31, twoTo31-1))
+}
+
+// Uint8 returns the integer value as a uint8.
+func (d *Decoder) Uint8() uint8 {
+	return uint8(d.integerInRange(0, 1<<8-1))
+}
+
+// Uint16 returns the integer value as a uint16.
+func (d *Decoder) Uint16() uint16 {
+	return uint16(d.integerInRange(0, 1<<16-1))
+}
+
+// Uint32 returns the integer value as a uint32.
+func (d *Decoder) Uint32() uint32 {
+	return uint32(d.integerInRange(0, 1<<32-1))
+}
+
+// Uint64 returns the integer value as a uint64.
+// Negative values are out of range.
+func (d *Decoder) Uint64() uint64 {
+	return uint64(d.integerInRange(0, int64(maxInt64)))
+}
+
+// Float32 returns the double value as a float32.
+// If the value is not a double, d.Error is set to ErrorUnexpectedType.
+// In strict mode, d.Error is set to ErrorInexactFloat32 if the value
+// cannot be represented exactly as a float32. In both cases 0 is returned.
+// NaN values are always accepted.
+func (d *Decoder) Float32() float32 {
+	if d.ValueType != Double {
+		d.Error = ErrorUnexpectedType
+		return 0
+	}
+	f := float32(d.ValueDouble)
+	if d.Strict && float64(f) != d.ValueDouble && !isNaN(d.ValueDouble) {
+		d.Error = ErrorInexactFloat32
+		return 0
+	}
+	return f
+}
+
 // Private methods
 
+// Returns ValueInteger if it is in the range [min, max].
+func (d *Decoder) integerInRange(min, max int64) int64 {
+	if d.ValueType != Integer {
+		d.Error = ErrorUnexpectedType
+		return 0
+	}
+	if d.ValueInteger < min || d.ValueInteger > max {
+		d.Error = ErrorOutOfRange
+		return 0
+	}
+	return d.ValueInteger
+}
+
 func (d *Decoder) parseValue(sigByte byte, afterValueState int) {
 	switch sigByte {
 	case sigBegin:
 		d.ValueType = Object
 		d.state = stateBeforeObject
+		d.depth++
 	case sigBeginArray:
 		d.ValueType = Array
 		d.state = stateBeforeArray
+		d.depth++
 	case sigFalse, sigTrue:
 		d.ValueType = Boolean
 		d.ValueBoolean = sigByte == sigTrue
@@ -338,58 +586,91 @@ func (d *Decoder) parseValue(sigByte byte, afterValueState int) {
 		d.readInt64(&i64)
 		d64 = float64frombits(uint64(i64))
 		d.ValueDouble = d64
-		d.state = afterValueState
+		d.afterValue(afterValueState)
 	case sigInteger1, sigInteger2, sigInteger4, sigInteger8:
 		d.ValueType = Integer
 		d.ValueInteger = d.parseInteger(sigByte)
-		d.state = afterValueState
+		d.afterValue(afterValueState)
 	case sigString1, sigString2, sigString4:
 		d.ValueType = String
 		d.ValueBytes = d.parseBytes(sigByte)
-		d.state = afterValueState
+		d.checkUTF8(d.ValueBytes)
+		d.afterValue(afterValueState)
 	case sigBytes1, sigBytes2, sigBytes4:
 		d.ValueType = Bytes
 		d.ValueBytes = d.parseBytes(sigByte)
-		d.state = afterValueState
+		d.afterValue(afterValueState)
 	default:
 		d.Error = ErrorUnexpectedTypeByte
 	}
 }
 
+// Sets the state after a value, unless reading the value failed.
+func (d *Decoder) afterValue(state int) {
+	if d.Error == ErrorNone {
+		d.state = state
+	}
+}
+
 // Parses the name of a field.
 func (d *Decoder) parseName(sigBeforeName byte) {
 	switch sigBeforeName {
 	case sigString1, sigString2, sigString4:
 		d.Name = d.parseBytes(sigBeforeName)
+		d.checkUTF8(d.Name)
 	default:
 		d.Error = ErrorUnexpectedType
 	}
 }
 
+// In strict mode, checks that b, the last parsed string,
+// is valid UTF-8.
+func (d *Decoder) checkUTF8(b []byte) {
+	if !d.Strict || d.Error != ErrorNone {
+		return
+	}
+	if i := invalidUTF8(b); i >= 0 {
+		d.Error = ErrorInvalidUTF8
+		d.ErrorOffset = d.offset - len(b) + i
+	}
+}
+
 func (d *Decoder) parseBegin() {
 	d.sigByte = d.readOne()
+	if d.Error != ErrorNone {
+		return
+	}
 
 	if d.sigByte != sigBegin {
 		d.Error = ErrorExpectedBegin
 		return
 	}
 	d.state = stateBeforeField
+	d.depth = 1
 }
 
 // Parses one of: field name bytes, string value, bytes value.
 func (d *Decoder) parseBytes(sigByte byte) []byte {
 	var length64 int64 = d.parseInteger(sigByte)
+	if d.Error != ErrorNone {
+		return nil
+	}
 	if length64 < 0 {
 		d.Error = ErrorNegativeLength
 		return nil
 	}
 
-	if length64 >= 2^31 {
+	maxLength := twoTo31 - 1
+	if d.MaxLength > 0 {
+		maxLength = int64(d.MaxLength)
+	}
+	if length64 > maxLength {
 		d.Error = ErrorLengthTooLarge
 		return nil
 	}
 	length := int(length64)
-	if d.offset+length > len(d.buf) {
+	// Does not overflow on targets with 32-bit int.
+	if length > len(d.buf)-d.offset {
 		d.Error = ErrorEOF
 		return nil
 	}
@@ -400,6 +681,9 @@ func (d *Decoder) parseBytes(sigByte byte) []byte {
 }
 
 func (d *Decoder) parseInteger(sigByte byte) int64 {
+	var min int64
+	var result int64
+
 	switch sigByte & intLengthMask {
 	case oneByte:
 		var i1 int8
@@ -408,18 +692,25 @@ func (d *Decoder) parseInteger(sigByte byte) int64 {
 	case twoBytes:
 		var i2 int16
 		d.readInt16(&i2)
-		return int64(i2)
+		result, min = int64(i2), twoTo7
 	case fourBytes:
 		var i4 int32
 		d.readInt32(&i4)
-		return int64(i4)
+		result, min = int64(i4), twoTo15
 	case eightBytes:
 		var i8 int64
 		d.readInt64(&i8)
-		return int64(i8)
+		result, min = i8, twoTo31
 	default:
 		panic("never happens")
 	}
+
+	// For Validate, the value must not fit in a shorter encoding.
+	if d.shortest && d.Error == ErrorNone && result >= -min && result < min {
+		d.Error = ErrorNotShortest
+	}
+
+	return result
 }
 
 // Reads one byte from the buffer.
@@ -459,6 +750,7 @@ func (d *Decoder) readInt32(a *int32) {
 	if d.offset+4 > len(d.buf) {
 		*a = 0
 		d.Error = ErrorEOF
+		return
 	}
 
 	myUint32 := getUint32(d.buf[d.offset:])
@@ -492,6 +784,504 @@ func (d *Decoder) readToBuffer(toBuffer []byte) {
 	d.offset += ln
 }
 
+// ======== Validate ========
+
+// Validate checks that buf contains exactly one Binson object in
+// canonical form. The object must be valid with a strict Decoder,
+// integers and lengths must use their shortest encoding, fields must
+// be sorted by name, field names must be unique within
+// an object, and there must be no bytes after the object.
+// Field names are sorted by their UTF-8 bytes, compared as unsigned
+// bytes; a name sorts before any longer name it is a prefix of.
+// Objects and arrays may be nested at most 100 levels deep.
+// Returns ErrorNone if buf is valid, otherwise one of the ErrorX codes.
+func Validate(buf []byte) int {
+	d := Decoder{Strict: true, shortest: true}
+	d.Init(buf)
+	d.parseBegin()
+	if d.Error != ErrorNone {
+		return d.Error
+	}
+
+	validateObject(&d, 1)
+	if d.Error != ErrorNone {
+		return d.Error
+	}
+	if d.offset != len(buf) {
+		return ErrorTrailingBytes
+	}
+	return ErrorNone
+}
+
+// Validates the fields of an object, d is positioned before the first
+// field. Returns with d after the end of the object.
+func validateObject(d *Decoder, depth int) {
+	var prev []byte
+	first := true
+
+	for d.NextField() {
+		if d.Error != ErrorNone {
+			return
+		}
+
+		if !first {
+			switch compareNames(prev, d.Name) {
+			case 0:
+				d.Error = ErrorDuplicateName
+				return
+			case 1:
+				d.Error = ErrorFieldOrder
+				return
+			}
+		}
+		first = false
+		prev = d.Name
+
+		if validateValue(d, depth) {
+			d.GoUpToObject()
+		}
+		if d.Error != ErrorNone {
+			return
+		}
+	}
+}
+
+// Validates the values of an array, d is positioned before the first
+// value. Returns with d after the end of the array.
+func validateArray(d *Decoder, depth int) {
+	for d.NextArrayValue() {
+		if d.Error != ErrorNone {
+			return
+		}
+		if validateValue(d, depth) {
+			d.GoUpToArray()
+		}
+		if d.Error != ErrorNone {
+			return
+		}
+	}
+}
+
+// Validates the contents of the last read value if it is
+// an object or an array. Other values are checked by the Decoder.
+// Returns true if the decoder was moved to the end of a nested
+// object or array, and must be moved up to its parent.
+func validateValue(d *Decoder, depth int) bool {
+	if d.Error != ErrorNone || (d.ValueType != Object && d.ValueType != Array) {
+		return false
+	}
+	if depth >= maxDepth {
+		d.Error = ErrorTooDeep
+		return false
+	}
+
+	if d.ValueType == Object {
+		d.GoIntoObject()
+		validateObject(d, depth+1)
+	} else {
+		d.GoIntoArray()
+		validateArray(d, depth+1)
+	}
+	return d.Error == ErrorNone
+}
+
+// Compares two field names in Binson sort order. Returns -1, 0 or 1.
+func compareNames(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// ======== Canonical form ========
+
+// Hash writes the canonical form of the Binson object in buf to h.
+// Objects that are equal except for field order and integer or length
+// encodings give the same hash. See Canonicalize.
+// A scratch buffer of len(buf) bytes is allocated, use HashScratch to
+// avoid the allocation.
+// Returns ErrorNone on success, otherwise one of the ErrorX codes.
+func Hash(buf []byte, h hash.Hash) int {
+	return HashScratch(buf, make([]byte, len(buf)), h)
+}
+
+// HashScratch is like Hash, but uses the caller-provided scratch
+// buffer for the canonical form. A scratch buffer of len(buf) bytes
+// is always large enough.
+func HashScratch(buf []byte, scratch []byte, h hash.Hash) int {
+	n, err := Canonicalize(scratch, buf)
+	if err != ErrorNone {
+		return err
+	}
+	h.Write(scratch[:n])
+	return ErrorNone
+}
+
+// Canonicalize writes the canonical form of the Binson object in src
+// to dst: fields sorted by name, and integers and lengths in their
+// shortest encoding. The result passes Validate.
+// Duplicate field names, invalid UTF-8, nesting deeper than 100 levels
+// and bytes after the object are errors. The canonical form is never
+// larger than src, so a dst of len(src) bytes is always large enough.
+// dst must not overlap src.
+// Returns the number of bytes written to dst and ErrorNone on success,
+// otherwise 0 and one of the ErrorX codes.
+//
+// No memory is allocated. Objects that are already sorted are written
+// in one pass. Other objects are sorted by scanning their fields once
+// per field (selection sort), so the time grows with the square of the
+// number of fields. To bound that time, unsorted objects with more than
+// 256 fields give ErrorTooManyFields.
+func Canonicalize(dst, src []byte) (int, int) {
+	d := Decoder{}
+	d.Init(src)
+	e := Encoder{}
+	e.Init(dst)
+
+	d.parseBegin()
+	if d.Error != ErrorNone {
+		return 0, d.Error
+	}
+	d.ValueType = Object
+	d.state = stateBeforeObject
+
+	canonicalValue(&e, &d, stateZero)
+	switch {
+	case d.Error != ErrorNone:
+		return 0, d.Error
+	case e.Error != ErrorNone:
+		return 0, e.Error
+	case d.offset != len(src):
+		return 0, ErrorTrailingBytes
+	}
+	return e.Offset, ErrorNone
+}
+
+// Writes the canonical form of the value last read by d to e.
+// For objects and arrays, d is left after the end of the value,
+// with state set to afterState. The nesting depth is checked with
+// d.depth, which counts the value.
+func canonicalValue(e *Encoder, d *Decoder, afterState int) {
+	switch d.ValueType {
+	case Boolean:
+		e.Bool(d.ValueBoolean)
+	case Integer:
+		e.Integer(d.ValueInteger)
+	case Double:
+		e.Double(d.ValueDouble)
+	case String:
+		e.stringBytes(d.ValueBytes)
+	case Bytes:
+		e.Bytes(d.ValueBytes)
+	case Array:
+		if d.depth > maxDepth {
+			d.Error = ErrorTooDeep
+			return
+		}
+		e.BeginArray()
+		d.state = stateBeforeArrayValue
+		for d.NextArrayValue() {
+			if d.Error != ErrorNone {
+				return
+			}
+			canonicalValue(e, d, stateBeforeArrayValue)
+			if d.Error != ErrorNone || e.Error != ErrorNone {
+				return
+			}
+		}
+		e.EndArray()
+		d.state = afterState
+	case Object:
+		if d.depth > maxDepth {
+			d.Error = ErrorTooDeep
+			return
+		}
+		canonicalObject(e, d)
+		d.state = afterState
+	}
+}
+
+// Writes the fields of the object that d is before in sorted order.
+// d is left after the end of the object, like NextField leaves it.
+func canonicalObject(e *Encoder, d *Decoder) {
+	start := d.offset
+
+	// One scan counts the fields and checks if they are already sorted.
+	// Duplicates are next to each other in sorted objects.
+	scan := *d
+	scan.state = stateBeforeField
+	count := 0
+	sorted := true
+	var prev []byte
+	for scan.NextField() {
+		if scan.Error != ErrorNone {
+			break
+		}
+		if count > 0 {
+			c := compareNames(prev, scan.Name)
+			if c == 0 {
+				d.Error = ErrorDuplicateName
+				return
+			}
+			sorted = sorted && c < 0
+		}
+		prev = scan.Name
+		count++
+	}
+	if scan.Error != ErrorNone {
+		d.Error = scan.Error
+		return
+	}
+
+	if sorted {
+		e.Begin()
+		d.state = stateBeforeField
+		for d.NextField() {
+			if d.Error != ErrorNone {
+				return
+			}
+			e.NameBytes(d.Name)
+			canonicalValue(e, d, stateBeforeField)
+			if d.Error != ErrorNone || e.Error != ErrorNone {
+				return
+			}
+		}
+		if d.Error == ErrorNone {
+			e.End()
+		}
+		return
+	}
+	if count > maxUnsortedFields {
+		d.Error = ErrorTooManyFields
+		return
+	}
+
+	end := start
+	var last []byte
+	first := true
+
+	e.Begin()
+	for {
+		// Find the smallest name after the last written one.
+		scan := *d
+		scan.offset = start
+		scan.state = stateBeforeField
+		var name []byte
+		valueOffset := -1
+
+		for scan.NextField() {
+			if scan.Error != ErrorNone {
+				break
+			}
+			if !first && compareNames(scan.Name, last) <= 0 {
+				continue
+			}
+			if valueOffset >= 0 {
+				c := compareNames(scan.Name, name)
+				if c == 0 {
+					d.Error = ErrorDuplicateName
+					return
+				}
+				if c > 0 {
+					continue
+				}
+			}
+			name = scan.Name
+			valueOffset = scan.valueOffset
+		}
+		if scan.Error != ErrorNone {
+			d.Error = scan.Error
+			return
+		}
+		end = scan.offset
+		if valueOffset < 0 {
+			break
+		}
+
+		// Write the field. d is moved to the value and back, a pointer
+		// to a copy of d would be allocated on the heap, since the
+		// calls are recursive.
+		e.NameBytes(name)
+		saved := *d
+		d.offset = valueOffset
+		d.parseValue(d.readOne(), stateBeforeField)
+		canonicalValue(e, d, stateBeforeField)
+		if d.Error != ErrorNone || e.Error != ErrorNone {
+			return
+		}
+		*d = saved
+
+		last = name
+		first = false
+	}
+	e.End()
+
+	d.offset = end
+	d.depth--
+}
+
+// ======== Merge ========
+
+// Merge writes the result of applying the Binson object patch to the
+// Binson object base to dst, with merge patch semantics (RFC 7386):
+// each field of patch replaces the field with the same name in base,
+// or is added. If both values are objects, they are merged recursively.
+// A field with an empty bytes value in patch removes the field from
+// base, so a patch cannot set a field to an empty bytes value; that
+// takes a base without the field, or a value that is not empty.
+// Other fields of base are kept. Arrays are replaced, not merged.
+// The fields of base and patch must be sorted, and the result is in
+// canonical form. dst must not overlap base or patch.
+// Returns the number of bytes written to dst and ErrorNone on success,
+// otherwise 0 and one of the ErrorX codes.
+//
+// No memory is allocated. Both inputs are read once, in field order.
+func Merge(dst, base, patch []byte) (int, int) {
+	b := Decoder{}
+	b.Init(base)
+	p := Decoder{}
+	p.Init(patch)
+	e := Encoder{}
+	e.Init(dst)
+
+	b.parseBegin()
+	p.parseBegin()
+	if b.Error == ErrorNone && p.Error == ErrorNone {
+		mergeObject(&e, &b, &p)
+	}
+	switch {
+	case b.Error != ErrorNone:
+		return 0, b.Error
+	case p.Error != ErrorNone:
+		return 0, p.Error
+	case e.Error != ErrorNone:
+		return 0, e.Error
+	case b.offset != len(base) || p.offset != len(patch):
+		return 0, ErrorTrailingBytes
+	}
+	return e.Offset, ErrorNone
+}
+
+// Writes the merge of the objects that b and p are positioned in to e.
+// b is nil when there is no base object. Returns with the decoders
+// after the end of their objects. The nesting depth is checked with
+// p.depth, b is at the same depth.
+func mergeObject(e *Encoder, b, p *Decoder) {
+	if p.depth > maxDepth {
+		p.Error = ErrorTooDeep
+		return
+	}
+
+	var prevB, prevP []byte
+	hasB := b != nil && mergeNextField(b, &prevB)
+	hasP := mergeNextField(p, &prevP)
+
+	e.Begin()
+	for hasB || hasP {
+		c := 0
+		switch {
+		case !hasP:
+			c = -1
+		case !hasB:
+			c = 1
+		default:
+			c = compareNames(b.Name, p.Name)
+		}
+
+		switch {
+		case c < 0:
+			// Only in base, keep the field.
+			e.NameBytes(b.Name)
+			canonicalValue(e, b, stateBeforeField)
+			hasB = mergeNextField(b, &prevB)
+		case c > 0:
+			mergeValue(e, nil, p)
+			hasP = mergeNextField(p, &prevP)
+		default:
+			mergeValue(e, b, p)
+			hasB = mergeNextField(b, &prevB)
+			hasP = mergeNextField(p, &prevP)
+		}
+		if (b != nil && b.Error != ErrorNone) || p.Error != ErrorNone || e.Error != ErrorNone {
+			return
+		}
+	}
+	if (b != nil && b.Error != ErrorNone) || p.Error != ErrorNone {
+		return
+	}
+	e.End()
+}
+
+// Writes the field last read by p, merged with the field with the same
+// name last read by b, if b is not nil.
+func mergeValue(e *Encoder, b, p *Decoder) {
+	if p.ValueType == Bytes && len(p.ValueBytes) == 0 {
+		return // removed
+	}
+
+	e.NameBytes(p.Name)
+	if p.ValueType != Object {
+		canonicalValue(e, p, stateBeforeField)
+		return
+	}
+
+	// Nested objects are merged. A patch object without a base object
+	// is merged with an empty object, so that removals in it are
+	// dropped.
+	if b != nil && b.ValueType != Object {
+		b = nil
+	}
+	if b != nil {
+		b.GoIntoObject()
+	}
+	p.GoIntoObject()
+	mergeObject(e, b, p)
+	if (b != nil && b.Error != ErrorNone) || p.Error != ErrorNone || e.Error != ErrorNone {
+		return
+	}
+	if b != nil {
+		b.GoUpToObject()
+	}
+	p.GoUpToObject()
+}
+
+// Reads the next field of an object being merged, and checks that
+// the fields are sorted. prev is the previous name, nil before the
+// first field. Returns false at the end of the object and on errors.
+func mergeNextField(d *Decoder, prev *[]byte) bool {
+	if d.Error != ErrorNone || !d.NextField() || d.Error != ErrorNone {
+		return false
+	}
+	if *prev != nil {
+		switch compareNames(*prev, d.Name) {
+		case 0:
+			d.Error = ErrorDuplicateName
+			return false
+		case 1:
+			d.Error = ErrorFieldOrder
+			return false
+		}
+	}
+	*prev = d.Name
+	return true
+}
+
 // ========= Encoder ========
 
 // An Encoder writes Binson data to an output buffer.
@@ -541,6 +1331,18 @@ func (e *Encoder) Integer(val int64) {
 	e.writeIntegerOrLength(sigInteger1, val)
 }
 
+// Uint64 writes specified unsigned integer value to output stream.
+// Binson integers are signed 64-bit values, so if val is larger
+// than the max int64 value, nothing is written and e.Error is set to
+// ErrorOutOfRange.
+func (e *Encoder) Uint64(val uint64) {
+	if val > maxInt64 {
+		e.Error = ErrorOutOfRange
+		return
+	}
+	e.Integer(int64(val))
+}
+
 // Double writes float64 value to output stream
 func (e *Encoder) Double(val float64) {
 	e.writeOne(sigDouble)
@@ -548,10 +1350,22 @@ func (e *Encoder) Double(val float64) {
 	e.writeInt64(int64(myUint))
 }
 
-// String writes string value to output stream
+// Float32 writes float32 value to output stream. Binson only has
+// 64-bit doubles, the value is converted without loss of precision.
+func (e *Encoder) Float32(val float32) {
+	e.Double(float64(val))
+}
+
+// String writes string value to output stream.
+// If val is not valid UTF-8, nothing is written and e.Error is set
+// to ErrorInvalidUTF8.
 func (e *Encoder) String(val string) {
+	if invalidUTF8(val) >= 0 {
+		e.Error = ErrorInvalidUTF8
+		return
+	}
 	e.writeIntegerOrLength(sigString1, int64(len(val)))
-	e.write([]byte(val))
+	e.writeString(val)
 }
 
 // Bytes writes []byte value to output stream
@@ -560,11 +1374,122 @@ func (e *Encoder) Bytes(val []byte) {
 	e.write(val)
 }
 
+// Like String, but takes the value as a byte slice.
+func (e *Encoder) stringBytes(val []byte) {
+	if invalidUTF8(val) >= 0 {
+		e.Error = ErrorInvalidUTF8
+		return
+	}
+	e.writeIntegerOrLength(sigString1, int64(len(val)))
+	e.write(val)
+}
+
 // Name writes string value as OBJECT item's name to output stream
 func (e *Encoder) Name(val string) {
 	e.String(val)
 }
 
+// NameBytes writes val as OBJECT item's name to output stream.
+// Useful when the name is available as a byte slice, like Decoder.Name.
+// If val is not valid UTF-8, nothing is written and e.Error is set
+// to ErrorInvalidUTF8.
+func (e *Encoder) NameBytes(val []byte) {
+	e.stringBytes(val)
+}
+
+// ======== Encoder, field writers ========
+// Each FieldX method writes a field name followed by its value.
+// The FieldXB variants take the name as a byte slice.
+// Fields must still be written in sorted order by the caller.
+
+// FieldBool writes a field with a boolean value.
+func (e *Encoder) FieldBool(name string, val bool) {
+	e.Name(name)
+	e.Bool(val)
+}
+
+// FieldInt writes a field with an integer value.
+func (e *Encoder) FieldInt(name string, val int64) {
+	e.Name(name)
+	e.Integer(val)
+}
+
+// FieldDouble writes a field with a double value.
+func (e *Encoder) FieldDouble(name string, val float64) {
+	e.Name(name)
+	e.Double(val)
+}
+
+// FieldString writes a field with a string value.
+func (e *Encoder) FieldString(name string, val string) {
+	e.Name(name)
+	e.String(val)
+}
+
+// FieldBytes writes a field with a bytes value.
+func (e *Encoder) FieldBytes(name string, val []byte) {
+	e.Name(name)
+	e.Bytes(val)
+}
+
+// FieldBeginObject writes a field name and the begin signature of
+// an OBJECT value. The object must be closed with End().
+func (e *Encoder) FieldBeginObject(name string) {
+	e.Name(name)
+	e.Begin()
+}
+
+// FieldBeginArray writes a field name and the begin signature of
+// an ARRAY value. The array must be closed with EndArray().
+func (e *Encoder) FieldBeginArray(name string) {
+	e.Name(name)
+	e.BeginArray()
+}
+
+// FieldBoolB writes a field with a boolean value.
+func (e *Encoder) FieldBoolB(name []byte, val bool) {
+	e.NameBytes(name)
+	e.Bool(val)
+}
+
+// FieldIntB writes a field with an integer value.
+func (e *Encoder) FieldIntB(name []byte, val int64) {
+	e.NameBytes(name)
+	e.Integer(val)
+}
+
+// FieldDoubleB writes a field with a double value.
+func (e *Encoder) FieldDoubleB(name []byte, val float64) {
+	e.NameBytes(name)
+	e.Double(val)
+}
+
+// FieldStringB writes a field with a string value.
+func (e *Encoder) FieldStringB(name []byte, val string) {
+	e.NameBytes(name)
+	e.String(val)
+}
+
+// FieldBytesB writes a field with a bytes value.
+func (e *Encoder) FieldBytesB(name []byte, val []byte) {
+	e.NameBytes(name)
+	e.Bytes(val)
+}
+
+// FieldBeginObjectB writes a field name and the begin signature of
+// an OBJECT value. The object must be closed with End().
+func (e *Encoder) FieldBeginObjectB(name []byte) {
+	e.NameBytes(name)
+	e.Begin()
+}
+
+// FieldBeginArrayB writes a field name and the begin signature of
+// an ARRAY value. The array must be closed with EndArray().
+func (e *Encoder) FieldBeginArrayB(name []byte) {
+	e.NameBytes(name)
+	e.BeginArray()
+}
+
 /* === private methods === */
 
 func (e *Encoder) writeIntegerOrLength(baseType byte, val int64) {
@@ -614,6 +1539,17 @@ func (e *Encoder) write(b []byte) {
 	e.Offset += lenb
 }
 
+// Like write, but copies from a string. Avoids the []byte(s)
+// conversion that may allocate.
+func (e *Encoder) writeString(s string) {
+	lens := len(s)
+	if !e.available(lens) {
+		return
+	}
+	copy(e.buf[e.Offset:], s)
+	e.Offset += lens
+}
+
 func (e *Encoder) writeInt8(i int8) {
 	e.writeOne(byte(i))
 }
@@ -670,54 +1606,70 @@ func float64frombits(b uint64) float64 {
 	return *(*float64)(unsafe.Pointer(&b))
 }
 
-// ======== Instead of binary ========
-// Code in this section removes dependency on binary package.
-// Little-endian encoding is assumed. As used by Binson.
-// Early bounds checks (decreasing indexes) see code in binary package and
-// golang.org/issue/14808. Can improve performance. Check TinyGo performance.
-//
-// CONSIDER. Given 32-bit machine (TinyGo on Cortex M0, for example), can we optimize
-// this? On little-ending machines, should be possible to do fast conversions
-// using Unsafe between uint32 and []byte. Possibly between unit64 and []byte as well.
-
-func getUint64(b []byte) uint64 {
-	_ = b[7] // early bounds check for performance
-	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
-		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+// IsNaN reports whether f is a "not-a-number" value.
+// Equivalent to math.IsNaN().
+func isNaN(f float64) bool {
+	const expMask uint64 = 0x7ff << 52
+	b := float64bits(f)
+	return b&expMask == expMask && b&(1<<52-1) != 0
 }
 
-func putUint64(b []byte, v uint64) {
-	_ = b[7] // early bounds check
-	b[0] = byte(v)
-	b[1] = byte(v >> 8)
-	b[2] = byte(v >> 16)
-	b[3] = byte(v >> 24)
-	b[4] = byte(v >> 32)
-	b[5] = byte(v >> 40)
-	b[6] = byte(v >> 48)
-	b[7] = byte(v >> 56)
-}
+// ======== Instead of unicode/utf8 ========
+// Code in this section removes dependency on the utf8 package.
+
+// Returns the index of the first byte in s that is not part of a valid
+// UTF-8 sequence, or -1 if s is valid UTF-8. Overlong encodings,
+// surrogate halves and code points above U+10FFFF are invalid,
+// as with utf8.Valid().
+func invalidUTF8[T string | []byte](s T) int {
+	n := len(s)
+	for i := 0; i < n; {
+		c := s[i]
+		if c < 0x80 {
+			i++
+			continue
+		}
 
-func getUint32(b []byte) uint32 {
-	_ = b[3] // early bounds check see golang.org/issue/14808
-	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
-}
+		// Length of the sequence and the allowed range of the
+		// second byte, see the table in RFC 3629, section 4.
+		size, lo, hi := 0, byte(0x80), byte(0xbf)
+		switch {
+		case c >= 0xc2 && c <= 0xdf:
+			size = 2
+		case c == 0xe0:
+			size, lo = 3, 0xa0
+		case c >= 0xe1 && c <= 0xec, c == 0xee, c == 0xef:
+			size = 3
+		case c == 0xed:
+			size, hi = 3, 0x9f
+		case c == 0xf0:
+			size, lo = 4, 0x90
+		case c >= 0xf1 && c <= 0xf3:
+			size = 4
+		case c == 0xf4:
+			size, hi = 4, 0x8f
+		default:
+			return i
+		}
 
-func putUint32(b []byte, v uint32) {
-	_ = b[3] // early bounds check, golang.org/issue/14808
-	b[0] = byte(v)
-	b[1] = byte(v >> 8)
-	b[2] = byte(v >> 16)
-	b[3] = byte(v >> 24)
-}
+		if i+size > n {
+			return i
+		}
+		if s[i+1] < lo || s[i+1] > hi {
+			return i
+		}
+		for j := 2; j < size; j++ {
+			if s[i+j] < 0x80 || s[i+j] > 0xbf {
+				return i
+			}
+		}
+		i += size
+	}
 
-func getUint16(b []byte) uint16 {
-	_ = b[1] // early bounds check
-	return uint16(b[0]) | uint16(b[1])<<8
+	return -1
 }
 
-func putUint16(b []byte, v uint16) {
-	_ = b[1] // early bounds check
-	b[0] = byte(v)
-	b[1] = byte(v >> 8)
-}
+// ======== Instead of binary ========
+// The little-endian conversions getUint16/32/64 and putUint16/32/64,
+// used instead of the binary package, are in endian_portable.go and
+// endian_fast.go. Build tags select one of them.
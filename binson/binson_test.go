@@ -2,7 +2,9 @@ package binson
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
+	"strconv"
 	"testing"
 )
 
@@ -222,6 +224,72 @@ func TestEncoderComplexObjectStructure2(t *testing.T) {
 	}
 }
 
+func TestEncoderFieldWriters(t *testing.T) {
+	// {"a":[],"b":true,"c":13,"d":1.0,"e":"x","f":"0x01","g":{}}
+	exp := []byte(
+		"\x40\x14\x01\x61\x42\x43\x14\x01\x62\x44\x14\x01\x63\x10\x0d" +
+			"\x14\x01\x64\x46\x00\x00\x00\x00\x00\x00\xf0\x3f\x14\x01\x65" +
+			"\x14\x01\x78\x14\x01\x66\x18\x01\x01\x14\x01\x67\x40\x41\x41",
+	)
+	b := make([]byte, 100)
+	e := newEncoderFromBytes(b)
+
+	e.Begin()
+	e.FieldBeginArray("a")
+	e.EndArray()
+	e.FieldBool("b", true)
+	e.FieldInt("c", 13)
+	e.FieldDouble("d", 1.0)
+	e.FieldString("e", "x")
+	e.FieldBytes("f", []byte("\x01"))
+	e.FieldBeginObject("g")
+	e.End()
+	e.End()
+
+	if e.Error != ErrorNone || e.Offset != len(exp) || !bytes.Equal(exp, b[:len(exp)]) {
+		t.Errorf("Binson encoder failure: expected 0x%v, got 0x%v", hex.EncodeToString(exp),
+			hex.EncodeToString(b[:e.Offset]))
+	}
+}
+
+func TestEncoderFieldWritersBytesName(t *testing.T) {
+	// {"a":[],"b":true,"c":13,"d":1.0,"e":"x","f":"0x01","g":{}}
+	exp := []byte(
+		"\x40\x14\x01\x61\x42\x43\x14\x01\x62\x44\x14\x01\x63\x10\x0d" +
+			"\x14\x01\x64\x46\x00\x00\x00\x00\x00\x00\xf0\x3f\x14\x01\x65" +
+			"\x14\x01\x78\x14\x01\x66\x18\x01\x01\x14\x01\x67\x40\x41\x41",
+	)
+	names := []byte("abcdefg")
+	b := make([]byte, 100)
+	e := newEncoderFromBytes(b)
+
+	e.Begin()
+	e.FieldBeginArrayB(names[0:1])
+	e.EndArray()
+	e.FieldBoolB(names[1:2], true)
+	e.FieldIntB(names[2:3], 13)
+	e.FieldDoubleB(names[3:4], 1.0)
+	e.FieldStringB(names[4:5], "x")
+	e.FieldBytesB(names[5:6], []byte("\x01"))
+	e.FieldBeginObjectB(names[6:7])
+	e.End()
+	e.End()
+
+	if e.Error != ErrorNone || e.Offset != len(exp) || !bytes.Equal(exp, b[:len(exp)]) {
+		t.Errorf("Binson encoder failure: expected 0x%v, got 0x%v", hex.EncodeToString(exp),
+			hex.EncodeToString(b[:e.Offset]))
+	}
+}
+
+func TestEncoderStringBufferTooSmall(t *testing.T) {
+	b := make([]byte, 4)
+	e := newEncoderFromBytes(b)
+
+	e.String("abc")
+	assertEqualInt64(t, int64(ErrorEOF), int64(e.Error))
+	assertEqualInt64(t, 2, int64(e.Offset))
+}
+
 func TestDecoderObjectEmpty(t *testing.T) {
 	d := newDecoderFromBytes([]byte("\x40\x41"))
 	gotField := d.NextField()
@@ -462,6 +530,708 @@ func TestDecoderArrayInArray1(t *testing.T) {
 	}
 }
 
+func TestEncoderUint64(t *testing.T) {
+	b := make([]byte, 100)
+	e := newEncoderFromBytes(b)
+
+	e.Uint64(9223372036854775807)
+	exp := []byte("\x13\xff\xff\xff\xff\xff\xff\xff\x7f")
+	if e.Error != ErrorNone || !bytes.Equal(exp, b[:e.Offset]) {
+		t.Errorf("Binson encoder failure: expected 0x%v, got 0x%v", hex.EncodeToString(exp),
+			hex.EncodeToString(b[:e.Offset]))
+	}
+
+	e.Uint64(9223372036854775808)
+	assertEqualInt64(t, int64(ErrorOutOfRange), int64(e.Error))
+	assertEqualInt64(t, int64(len(exp)), int64(e.Offset))
+}
+
+func TestDecoderIntegerAccessors(t *testing.T) {
+	// {"a":-129,"b":-1,"c":255,"d":65536,"e":4294967296}
+	d := newDecoderFromBytes([]byte(
+		"\x40\x14\x01\x61\x11\x7f\xff\x14\x01\x62\x10\xff\x14\x01\x63\x11\xff\x00" +
+			"\x14\x01\x64\x12\x00\x00\x01\x00\x14\x01\x65\x13\x00\x00\x00\x00\x01\x00\x00\x00\x41"))
+
+	d.Field("a")
+	assertEqualInt64(t, -129, int64(d.Int16()))
+	assertEqualInt64(t, -129, int64(d.Int32()))
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+	assertEqualInt64(t, 0, int64(d.Int8()))
+	assertEqualInt64(t, ErrorOutOfRange, int64(d.Error))
+
+	d.Error = ErrorNone
+	d.Field("b")
+	assertEqualInt64(t, -1, int64(d.Int8()))
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+	assertEqualInt64(t, 0, int64(d.Uint64()))
+	assertEqualInt64(t, ErrorOutOfRange, int64(d.Error))
+
+	d.Error = ErrorNone
+	d.Field("c")
+	assertEqualInt64(t, 255, int64(d.Uint8()))
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+	assertEqualInt64(t, 0, int64(d.Int8()))
+	assertEqualInt64(t, ErrorOutOfRange, int64(d.Error))
+
+	d.Error = ErrorNone
+	d.Field("d")
+	assertEqualInt64(t, 65536, int64(d.Uint32()))
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+	assertEqualInt64(t, 0, int64(d.Uint16()))
+	assertEqualInt64(t, ErrorOutOfRange, int64(d.Error))
+
+	d.Error = ErrorNone
+	d.Field("e")
+	assertEqualInt64(t, 4294967296, int64(d.Uint64()))
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+	assertEqualInt64(t, 0, int64(d.Uint32()))
+	assertEqualInt64(t, ErrorOutOfRange, int64(d.Error))
+}
+
+func TestDecoderIntegerAccessorWrongType(t *testing.T) {
+	// {"a":"x"}
+	d := newDecoderFromBytes([]byte("\x40\x14\x01\x61\x14\x01\x78\x41"))
+
+	d.Field("a")
+	assertEqualInt64(t, 0, int64(d.Uint8()))
+	assertEqualInt64(t, ErrorUnexpectedType, int64(d.Error))
+}
+
+func TestFloat32(t *testing.T) {
+	b := make([]byte, 100)
+	e := newEncoderFromBytes(b)
+
+	e.Begin()
+	e.Name("a")
+	e.Float32(0.1)
+	e.Name("b")
+	e.Double(0.1)
+	e.End()
+
+	d := newDecoderFromBytes(b[:e.Offset])
+	d.Strict = true
+	d.Field("a")
+	assertTrue(t, d.Float32() == float32(0.1), "expected 0.1")
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+
+	d.Field("b")
+	assertTrue(t, d.Float32() == 0, "expected 0")
+	assertEqualInt64(t, ErrorInexactFloat32, int64(d.Error))
+
+	d.Init(b[:e.Offset])
+	assertTrue(t, d.Strict, "expected Strict to be kept by Init")
+	d.Strict = false
+	d.Field("b")
+	assertTrue(t, d.Float32() == float32(0.1), "expected 0.1 in non-strict mode")
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+}
+
+func TestFloat32NaN(t *testing.T) {
+	b := make([]byte, 100)
+	e := newEncoderFromBytes(b)
+	var zero float64
+
+	e.Begin()
+	e.Name("a")
+	e.Double(zero / zero)
+	e.End()
+
+	d := newDecoderFromBytes(b[:e.Offset])
+	d.Strict = true
+	d.Field("a")
+	f := d.Float32()
+	assertTrue(t, f != f, "expected NaN")
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+}
+
+func TestEncoderInvalidUTF8(t *testing.T) {
+	b := make([]byte, 100)
+	e := newEncoderFromBytes(b)
+
+	e.Begin()
+	e.Name("a\xff")
+	assertEqualInt64(t, ErrorInvalidUTF8, int64(e.Error))
+	assertEqualInt64(t, 1, int64(e.Offset))
+
+	e = newEncoderFromBytes(b)
+	e.Begin()
+	e.NameBytes([]byte("a\xff"))
+	assertEqualInt64(t, ErrorInvalidUTF8, int64(e.Error))
+
+	e = newEncoderFromBytes(b)
+	e.Begin()
+	e.FieldString("a", "\xc0\xaf")
+	assertEqualInt64(t, ErrorInvalidUTF8, int64(e.Error))
+	assertEqualInt64(t, 4, int64(e.Offset))
+}
+
+func TestDecoderStrictInvalidUTF8(t *testing.T) {
+	// {"a":"b\xff"}
+	buf := []byte("\x40\x14\x01\x61\x14\x02\x62\xff\x41")
+
+	d := newDecoderFromBytes(buf)
+	assertEqualBool(t, true, d.Field("a"))
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+
+	d.Init(buf)
+	d.Strict = true
+	assertEqualBool(t, false, d.Field("a"))
+	assertEqualInt64(t, ErrorInvalidUTF8, int64(d.Error))
+	assertEqualInt64(t, 7, int64(d.ErrorOffset))
+
+	// {"\xe7\x88":1}
+	buf = []byte("\x40\x14\x02\xe7\x88\x10\x01\x41")
+	d.Init(buf)
+	d.NextField()
+	assertEqualInt64(t, ErrorInvalidUTF8, int64(d.Error))
+	assertEqualInt64(t, 3, int64(d.ErrorOffset))
+}
+
+func TestDecoderStrictLongEncodings(t *testing.T) {
+	// {"a":1}, with a 2 byte integer and a 2 byte name length
+	buf := []byte("\x40\x15\x01\x00\x61\x11\x01\x00\x41")
+
+	d := newDecoderFromBytes(buf)
+	d.Strict = true
+	assertEqualBool(t, true, d.Field("a"))
+	assertEqualInt64(t, 1, d.ValueInteger)
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+	assertEqualInt64(t, ErrorNotShortest, int64(Validate(buf)))
+}
+
+// Validate test data table
+var validateTable = []struct {
+	raw []byte
+	err int
+}{
+	{[]byte("\x40\x41"), ErrorNone},                                         // {}
+	{[]byte("\x40\x14\x01\x61\x10\x01\x14\x01\x62\x10\x02\x41"), ErrorNone}, // {"a":1,"b":2}
+	{[]byte("\x40\x14\x01\x61\x10\x01\x14\x02\x61\x61\x10\x02\x41"), ErrorNone},
+	{[]byte("\x40\x14\x01\x62\x10\x01\x14\x01\x61\x10\x02\x41"), ErrorFieldOrder},
+	{[]byte("\x40\x14\x02\x61\x61\x10\x01\x14\x01\x61\x10\x02\x41"), ErrorFieldOrder},
+	{[]byte("\x40\x14\x01\x61\x10\x01\x14\x01\x61\x10\x02\x41"), ErrorDuplicateName},
+	{[]byte("\x40\x14\x01\x61\x11\x01\x00\x41"), ErrorNotShortest},
+	{[]byte("\x40\x15\x01\x00\x61\x10\x01\x41"), ErrorNotShortest},
+	{[]byte("\x40\x14\x01\x61\x14\x01\xff\x41"), ErrorInvalidUTF8},
+	{[]byte("\x40\x41\x00"), ErrorTrailingBytes},
+	{[]byte("\x42\x43"), ErrorExpectedBegin},
+	{[]byte("\x40\x14\x01\x61\x10\x01"), ErrorEOF},
+
+	// {"a":{"b":1,"c":[{"e":1,"d":2}]}}, field order in nested object
+	{[]byte("\x40\x14\x01\x61\x40\x14\x01\x62\x10\x01\x14\x01\x63\x42\x40\x14\x01\x65" +
+		"\x10\x01\x14\x01\x64\x10\x02\x41\x43\x41\x41"), ErrorFieldOrder},
+
+	// {"a":{"c":1},"b":[{"a":1}],"c":2}, names in nested objects do
+	// not affect field order
+	{[]byte("\x40\x14\x01\x61\x40\x14\x01\x63\x10\x01\x41\x14\x01\x62\x42\x40\x14\x01" +
+		"\x61\x10\x01\x41\x43\x14\x01\x63\x10\x02\x41"), ErrorNone},
+}
+
+func TestValidate(t *testing.T) {
+	for i, record := range validateTable {
+		if err := Validate(record.raw); err != record.err {
+			t.Errorf("Validate failed, record %d: expected %d != recieved: %d", i, record.err, err)
+		}
+	}
+}
+
+func TestValidateTooDeep(t *testing.T) {
+	b := make([]byte, 1000)
+	for depth := 99; depth <= 101; depth++ {
+		// {"":[[...]]}, depth levels of nesting
+		e := newEncoderFromBytes(b)
+		e.Begin()
+		e.Name("")
+		for i := 1; i < depth; i++ {
+			e.BeginArray()
+		}
+		for i := 1; i < depth; i++ {
+			e.EndArray()
+		}
+		e.End()
+
+		exp := ErrorNone
+		if depth > 100 {
+			exp = ErrorTooDeep
+		}
+		assertEqualInt64(t, int64(exp), int64(Validate(b[:e.Offset])))
+	}
+}
+
+func TestHashFieldOrder(t *testing.T) {
+	// {"a":1,"b":{"c":[{"d":2,"e":3}]}}
+	a := []byte("\x40\x14\x01\x61\x10\x01\x14\x01\x62\x40\x14\x01\x63\x42\x40\x14\x01\x64" +
+		"\x10\x02\x14\x01\x65\x10\x03\x41\x43\x41\x41")
+	// {"b":{"c":[{"e":3,"d":2}]},"a":1}, unsorted and with over-wide
+	// integers and lengths
+	b := []byte("\x40\x14\x01\x62\x40\x15\x01\x00\x63\x42\x40\x14\x01\x65\x12\x03\x00\x00\x00" +
+		"\x14\x01\x64\x10\x02\x41\x43\x41\x14\x01\x61\x13\x01\x00\x00\x00\x00\x00\x00\x00\x41")
+
+	ha := sha256.New()
+	assertEqualInt64(t, ErrorNone, int64(Hash(a, ha)))
+	hb := sha256.New()
+	assertEqualInt64(t, ErrorNone, int64(Hash(b, hb)))
+
+	if !bytes.Equal(ha.Sum(nil), hb.Sum(nil)) {
+		t.Errorf("expected equal hashes")
+	}
+
+	exp := sha256.Sum256(a)
+	if !bytes.Equal(exp[:], ha.Sum(nil)) {
+		t.Errorf("expected hash of canonical object")
+	}
+}
+
+func TestHashScratch(t *testing.T) {
+	// {"b":2,"a":1}
+	buf := []byte("\x40\x14\x01\x62\x10\x02\x14\x01\x61\x10\x01\x41")
+	h := sha256.New()
+
+	assertEqualInt64(t, ErrorEOF, int64(HashScratch(buf, make([]byte, 5), h)))
+	assertEqualInt64(t, ErrorNone, int64(HashScratch(buf, make([]byte, len(buf)), h)))
+
+	exp := sha256.Sum256([]byte("\x40\x14\x01\x61\x10\x01\x14\x01\x62\x10\x02\x41"))
+	if !bytes.Equal(exp[:], h.Sum(nil)) {
+		t.Errorf("expected hash of canonical object")
+	}
+}
+
+func TestHashDuplicateName(t *testing.T) {
+	// {"b":1,"a":1,"b":2}
+	buf := []byte("\x40\x14\x01\x62\x10\x01\x14\x01\x61\x10\x01\x14\x01\x62\x10\x02\x41")
+	assertEqualInt64(t, ErrorDuplicateName, int64(Hash(buf, sha256.New())))
+}
+
+// Canonicalize test data table
+var canonicalizeTable = []struct {
+	src []byte
+	exp []byte
+	err int
+}{
+	// {} -> {}
+	{[]byte("\x40\x41"), []byte("\x40\x41"), ErrorNone},
+
+	// {"b":true,"a":"x"} -> {"a":"x","b":true}
+	{[]byte("\x40\x14\x01\x62\x44\x14\x01\x61\x14\x01\x78\x41"),
+		[]byte("\x40\x14\x01\x61\x14\x01\x78\x14\x01\x62\x44\x41"), ErrorNone},
+
+	// {"aa":1,"a":2,"":3}, over-wide integers and lengths
+	{[]byte("\x40\x16\x02\x00\x00\x00\x61\x61\x11\x01\x00\x14\x01\x61\x12\x02\x00\x00\x00" +
+		"\x14\x00\x13\x03\x00\x00\x00\x00\x00\x00\x00\x41"),
+		[]byte("\x40\x14\x00\x10\x03\x14\x01\x61\x10\x02\x14\x02\x61\x61\x10\x01\x41"), ErrorNone},
+
+	// {"a":[{"c":1.0,"b":"0x00"},[]]} -> {"a":[{"b":"0x00","c":1.0},[]]}
+	{[]byte("\x40\x14\x01\x61\x42\x40\x14\x01\x63\x46\x00\x00\x00\x00\x00\x00\xf0\x3f" +
+		"\x14\x01\x62\x19\x01\x00\x00\x41\x42\x43\x43\x41"),
+		[]byte("\x40\x14\x01\x61\x42\x40\x14\x01\x62\x18\x01\x00\x14\x01\x63" +
+			"\x46\x00\x00\x00\x00\x00\x00\xf0\x3f\x41\x42\x43\x43\x41"), ErrorNone},
+
+	// {"b":{"x":1,"x":2},"a":1}, duplicate name in nested object
+	{[]byte("\x40\x14\x01\x62\x40\x14\x01\x78\x10\x01\x14\x01\x78\x10\x02\x41\x14\x01\x61\x10\x01\x41"),
+		nil, ErrorDuplicateName},
+
+	// {"a":"\xff"}
+	{[]byte("\x40\x14\x01\x61\x14\x01\xff\x41"), nil, ErrorInvalidUTF8},
+
+	// {"a":1}, truncated
+	{[]byte("\x40\x14\x01\x61\x10\x01"), nil, ErrorEOF},
+
+	// {}{}
+	{[]byte("\x40\x41\x40\x41"), nil, ErrorTrailingBytes},
+}
+
+func TestCanonicalize(t *testing.T) {
+	for i, record := range canonicalizeTable {
+		dst := make([]byte, len(record.src))
+		n, err := Canonicalize(dst, record.src)
+		if err != record.err {
+			t.Errorf("Canonicalize failed, record %d: expected error %d != recieved: %d", i, record.err, err)
+			continue
+		}
+		if !bytes.Equal(record.exp, dst[:n]) {
+			t.Errorf("Canonicalize failed, record %d: expected 0x%v != recieved: 0x%v", i,
+				hex.EncodeToString(record.exp), hex.EncodeToString(dst[:n]))
+		}
+		if err == ErrorNone && Validate(dst[:n]) != ErrorNone {
+			t.Errorf("Canonicalize failed, record %d: result not valid", i)
+		}
+	}
+}
+
+func TestCanonicalizeIsIdentityForValid(t *testing.T) {
+	for i, record := range validateTable {
+		if record.err != ErrorNone {
+			continue
+		}
+		dst := make([]byte, len(record.raw))
+		n, err := Canonicalize(dst, record.raw)
+		if err != ErrorNone || !bytes.Equal(record.raw, dst[:n]) {
+			t.Errorf("Canonicalize failed, record %d: error %d, got 0x%v", i, err, hex.EncodeToString(dst[:n]))
+		}
+	}
+}
+
+// Returns an object with n integer fields named by 4 digit numbers,
+// sorted or in reverse order.
+func manyFields(n int, sorted bool) []byte {
+	buf := make([]byte, 2+9*n)
+	e := newEncoderFromBytes(buf)
+	e.Begin()
+	for i := 0; i < n; i++ {
+		j := i
+		if !sorted {
+			j = n - 1 - i
+		}
+		e.FieldInt(strconv.Itoa(1000+j), 1)
+	}
+	e.End()
+	return buf[:e.Offset]
+}
+
+func TestCanonicalizeManyFields(t *testing.T) {
+	// Sorted objects take one pass, and have no field limit.
+	src := manyFields(9000, true)
+	dst := make([]byte, len(src))
+	n, err := Canonicalize(dst, src)
+	if err != ErrorNone || !bytes.Equal(src, dst[:n]) {
+		t.Errorf("sorted: error %d", err)
+	}
+
+	for _, count := range []int{256, 257} {
+		exp := ErrorNone
+		if count > 256 {
+			exp = ErrorTooManyFields
+		}
+		src := manyFields(count, false)
+		n, err := Canonicalize(make([]byte, len(src)), src)
+		assertEqualInt64(t, int64(exp), int64(err))
+		if err == ErrorNone {
+			assertEqualInt64(t, int64(len(src)), int64(n))
+		}
+	}
+}
+
+func TestDecoderOffsetAndConsumed(t *testing.T) {
+	// {"a":1,"b":{"c":[3]},"d":4}{}
+	buf := []byte("\x40\x14\x01\x61\x10\x01\x14\x01\x62\x40\x14\x01\x63\x42\x10\x03\x43\x41" +
+		"\x14\x01\x64\x10\x04\x41\x40\x41")
+	size := len(buf) - 2
+
+	d := newDecoderFromBytes(buf)
+	assertEqualInt64(t, 0, int64(d.Offset()))
+	assertEqualInt64(t, int64(size), int64(d.Consumed()))
+	assertEqualInt64(t, int64(size), int64(d.Offset()))
+	assertEqualBool(t, false, d.NextField())
+
+	d.Init(buf)
+	d.Field("a")
+	assertEqualInt64(t, 6, int64(d.Offset()))
+	assertEqualInt64(t, int64(size), int64(d.Consumed()))
+
+	d.Init(buf)
+	d.Field("b")
+	d.GoIntoObject()
+	d.Field("c")
+	d.GoIntoArray()
+	d.NextArrayValue()
+	assertEqualInt64(t, int64(size), int64(d.Consumed()))
+
+	d.Init(buf)
+	d.Field("d")
+	assertEqualBool(t, false, d.NextField())
+	assertEqualInt64(t, int64(size), int64(d.Consumed()))
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+
+	d.Init(buf[:size-1])
+	assertEqualInt64(t, 0, int64(d.Consumed()))
+	assertEqualInt64(t, ErrorEOF, int64(d.Error))
+}
+
+// Objects with invalid structure, Consumed and Objects must not
+// accept them.
+var consumedInvalidTable = []struct {
+	raw []byte
+	err int
+}{
+	{[]byte("\x40\x10\x01\x41"), ErrorUnexpectedType},                                     // value without name
+	{[]byte("\x40\x14\x01\x61\x10\x01\x10\x02\x41"), ErrorUnexpectedType},                 // second value without name
+	{[]byte("\x40\x43"), ErrorUnexpectedType},                                             // object ended by array end
+	{[]byte("\x40\x14\x01\x61\x40\x43\x41"), ErrorUnexpectedType},                         // nested object ended by array end
+	{[]byte("\x40\x14\x01\x61\x42\x41\x41"), ErrorUnexpectedTypeByte},                     // array ended by object end
+	{[]byte("\x40\x14\x01\x61\x42\x14\x01\x62\x10\x01\x41\x41"), ErrorUnexpectedTypeByte}, // object end after array values
+}
+
+func TestConsumedInvalid(t *testing.T) {
+	for i, record := range consumedInvalidTable {
+		d := newDecoderFromBytes(record.raw)
+		if n := d.Consumed(); n != 0 || d.Error != record.err {
+			t.Errorf("Consumed accepted record %d: %d bytes, error %d, expected %d", i, n, d.Error, record.err)
+		}
+
+		it := Objects(record.raw)
+		if it.Next() || it.Error != record.err {
+			t.Errorf("Objects accepted record %d: error %d, expected %d", i, it.Error, record.err)
+		}
+	}
+}
+
+func TestIsObject(t *testing.T) {
+	assertEqualBool(t, true, IsObject([]byte("\x40\x41")))
+	// {"b":1,"a":[1]}, not canonical
+	assertEqualBool(t, true, IsObject([]byte("\x40\x14\x01\x62\x10\x01\x14\x01\x61\x42\x10\x01\x43\x41")))
+	assertEqualBool(t, false, IsObject(nil))
+	assertEqualBool(t, false, IsObject([]byte("\x40\x41\x40\x41")))
+	assertEqualBool(t, false, IsObject([]byte("\x42\x43")))
+	for _, record := range consumedInvalidTable {
+		assertEqualBool(t, false, IsObject(record.raw))
+	}
+}
+
+func TestObjects(t *testing.T) {
+	// {"a":1}{}{"b":[{}]}
+	buf := []byte("\x40\x14\x01\x61\x10\x01\x41\x40\x41\x40\x14\x01\x62\x42\x40\x41\x43\x41")
+	exp := [][]byte{buf[0:7], buf[7:9], buf[9:]}
+
+	it := Objects(buf)
+	i := 0
+	for it.Next() {
+		if i >= len(exp) || !bytes.Equal(exp[i], it.Object()) {
+			t.Fatalf("unexpected object %d: 0x%v", i, hex.EncodeToString(it.Object()))
+		}
+		i++
+	}
+	assertEqualInt64(t, int64(len(exp)), int64(i))
+	assertEqualInt64(t, ErrorNone, int64(it.Error))
+	assertEqualInt64(t, int64(len(buf)), int64(it.Offset()))
+
+	it = Objects(buf[:len(buf)-1])
+	assertEqualBool(t, true, it.Next())
+	assertEqualBool(t, true, it.Next())
+	assertEqualBool(t, false, it.Next())
+	assertEqualInt64(t, ErrorEOF, int64(it.Error))
+	assertEqualInt64(t, 9, int64(it.Offset()))
+
+	it = Objects([]byte("\x40\x41\x00"))
+	assertEqualBool(t, true, it.Next())
+	assertEqualBool(t, false, it.Next())
+	assertEqualInt64(t, ErrorExpectedBegin, int64(it.Error))
+
+	it = Objects(nil)
+	assertEqualBool(t, false, it.Next())
+	assertEqualInt64(t, ErrorNone, int64(it.Error))
+}
+
+// Merge test data table
+var mergeTable = []struct {
+	base  []byte
+	patch []byte
+	exp   []byte
+	err   int
+}{
+	// {"a":1,"b":2} + {"b":3,"c":4} -> {"a":1,"b":3,"c":4}
+	{[]byte("\x40\x14\x01\x61\x10\x01\x14\x01\x62\x10\x02\x41"),
+		[]byte("\x40\x14\x01\x62\x10\x03\x14\x01\x63\x10\x04\x41"),
+		[]byte("\x40\x14\x01\x61\x10\x01\x14\x01\x62\x10\x03\x14\x01\x63\x10\x04\x41"), ErrorNone},
+
+	// {"a":1,"b":{"c":1}} + {"b":0x} -> {"a":1}
+	{[]byte("\x40\x14\x01\x61\x10\x01\x14\x01\x62\x40\x14\x01\x63\x10\x01\x41\x41"),
+		[]byte("\x40\x14\x01\x62\x18\x00\x41"),
+		[]byte("\x40\x14\x01\x61\x10\x01\x41"), ErrorNone},
+
+	// {"b":{"c":1,"d":2}} + {"b":{"c":0x,"e":[3]}} -> {"b":{"d":2,"e":[3]}}
+	{[]byte("\x40\x14\x01\x62\x40\x14\x01\x63\x10\x01\x14\x01\x64\x10\x02\x41\x41"),
+		[]byte("\x40\x14\x01\x62\x40\x14\x01\x63\x18\x00\x14\x01\x65\x42\x10\x03\x43\x41\x41"),
+		[]byte("\x40\x14\x01\x62\x40\x14\x01\x64\x10\x02\x14\x01\x65\x42\x10\x03\x43\x41\x41"), ErrorNone},
+
+	// {"a":1} + {"a":{"x":0x,"y":true}} -> {"a":{"y":true}}
+	{[]byte("\x40\x14\x01\x61\x10\x01\x41"),
+		[]byte("\x40\x14\x01\x61\x40\x14\x01\x78\x18\x00\x14\x01\x79\x44\x41\x41"),
+		[]byte("\x40\x14\x01\x61\x40\x14\x01\x79\x44\x41\x41"), ErrorNone},
+
+	// {"a":0x01} + {"a":0x} -> {}, a field cannot be set to empty bytes
+	{[]byte("\x40\x14\x01\x61\x18\x01\x01\x41"), []byte("\x40\x14\x01\x61\x18\x00\x41"),
+		[]byte("\x40\x41"), ErrorNone},
+
+	// {} + {"a":0x} -> {}
+	{[]byte("\x40\x41"), []byte("\x40\x14\x01\x61\x18\x00\x41"), []byte("\x40\x41"), ErrorNone},
+
+	// {"a":[1,2]} + {"a":[3]} -> {"a":[3]}
+	{[]byte("\x40\x14\x01\x61\x42\x10\x01\x10\x02\x43\x41"),
+		[]byte("\x40\x14\x01\x61\x42\x10\x03\x43\x41"),
+		[]byte("\x40\x14\x01\x61\x42\x10\x03\x43\x41"), ErrorNone},
+
+	// {"a":1} + {} -> {"a":1}, over-wide integer
+	{[]byte("\x40\x14\x01\x61\x11\x01\x00\x41"), []byte("\x40\x41"),
+		[]byte("\x40\x14\x01\x61\x10\x01\x41"), ErrorNone},
+
+	// {} + {"b":1,"a":1}, unsorted patch
+	{[]byte("\x40\x41"), []byte("\x40\x14\x01\x62\x10\x01\x14\x01\x61\x10\x01\x41"), nil, ErrorFieldOrder},
+
+	// {"a":1,"a":2} + {}, duplicate name in base
+	{[]byte("\x40\x14\x01\x61\x10\x01\x14\x01\x61\x10\x02\x41"), []byte("\x40\x41"), nil, ErrorDuplicateName},
+
+	// {"a":1}, truncated base
+	{[]byte("\x40\x14\x01\x61\x10\x01"), []byte("\x40\x41"), nil, ErrorEOF},
+
+	// {}{}, trailing bytes in patch
+	{[]byte("\x40\x41"), []byte("\x40\x41\x40\x41"), nil, ErrorTrailingBytes},
+}
+
+func TestMerge(t *testing.T) {
+	for i, record := range mergeTable {
+		dst := make([]byte, 100)
+		n, err := Merge(dst, record.base, record.patch)
+		if err != record.err {
+			t.Errorf("Merge failed, record %d: expected error %d != recieved: %d", i, record.err, err)
+			continue
+		}
+		if !bytes.Equal(record.exp, dst[:n]) {
+			t.Errorf("Merge failed, record %d: expected 0x%v != recieved: 0x%v", i,
+				hex.EncodeToString(record.exp), hex.EncodeToString(dst[:n]))
+		}
+		if err == ErrorNone && Validate(dst[:n]) != ErrorNone {
+			t.Errorf("Merge failed, record %d: result not valid", i)
+		}
+	}
+}
+
+func TestMergeTooDeep(t *testing.T) {
+	b := make([]byte, 1000)
+	dst := make([]byte, 1000)
+	for depth := 99; depth <= 101; depth++ {
+		// {"a":{},"b":{},"c":[[...]]}, depth levels of nesting after
+		// objects that are canonicalized
+		e := newEncoderFromBytes(b)
+		e.Begin()
+		e.Name("a")
+		e.Begin()
+		e.End()
+		e.Name("b")
+		e.Begin()
+		e.End()
+		e.Name("c")
+		for i := 1; i < depth; i++ {
+			e.BeginArray()
+		}
+		for i := 1; i < depth; i++ {
+			e.EndArray()
+		}
+		e.End()
+		deep := b[:e.Offset]
+
+		exp := ErrorNone
+		if depth > 100 {
+			exp = ErrorTooDeep
+		}
+		_, err := Canonicalize(dst, deep)
+		assertEqualInt64(t, int64(exp), int64(err))
+		_, err = Merge(dst, deep, []byte("\x40\x41"))
+		assertEqualInt64(t, int64(exp), int64(err))
+		_, err = Merge(dst, []byte("\x40\x41"), deep)
+		assertEqualInt64(t, int64(exp), int64(err))
+	}
+}
+
+func TestMergeShortBuffer(t *testing.T) {
+	record := mergeTable[0]
+	for size := 0; size < len(record.exp); size++ {
+		n, err := Merge(make([]byte, size), record.base, record.patch)
+		assertEqualInt64(t, ErrorEOF, int64(err))
+		assertEqualInt64(t, 0, int64(n))
+	}
+}
+
+// String and bytes lengths at the boundaries of the length encodings
+var lengthTable = []struct {
+	length    int
+	sigString byte
+	sigBytes  byte
+}{
+	{0, sigString1, sigBytes1},
+	{127, sigString1, sigBytes1},
+	{128, sigString2, sigBytes2},
+	{32767, sigString2, sigBytes2},
+	{32768, sigString4, sigBytes4},
+	{5 << 20, sigString4, sigBytes4},
+}
+
+func TestLengthBoundaries(t *testing.T) {
+	for _, record := range lengthTable {
+		value := bytes.Repeat([]byte("x"), record.length)
+		for _, isString := range []bool{true, false} {
+			// {"a":<value>}
+			b := make([]byte, record.length+10)
+			e := newEncoderFromBytes(b)
+			e.Begin()
+			e.Name("a")
+			sig := record.sigBytes
+			if isString {
+				e.String(string(value))
+				sig = record.sigString
+			} else {
+				e.Bytes(value)
+			}
+			e.End()
+			assertEqualInt64(t, ErrorNone, int64(e.Error))
+			assertEqualInt64(t, int64(sig), int64(b[4]))
+
+			buf := b[:e.Offset]
+			assertEqualInt64(t, ErrorNone, int64(Validate(buf)))
+
+			d := newDecoderFromBytes(buf)
+			d.MaxLength = record.length
+			assertEqualBool(t, true, d.Field("a"))
+			assertEqualInt64(t, ErrorNone, int64(d.Error))
+			if !bytes.Equal(value, d.ValueBytes) {
+				t.Errorf("length %d: unexpected value", record.length)
+			}
+
+			if record.length > 1 {
+				d.MaxLength = record.length - 1
+				d.Init(buf)
+				d.Field("a")
+				assertEqualInt64(t, ErrorLengthTooLarge, int64(d.Error))
+			}
+		}
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	// {"a":{"bc":"xyz"},"d":1}
+	buf := []byte("\x40\x14\x01\x61\x40\x14\x02\x62\x63\x14\x03\x78\x79\x7a\x41\x14\x01\x64\x10\x01\x41")
+	d := Decoder{MaxLength: 3}
+	d.Init(buf)
+	assertEqualBool(t, true, d.Field("d"))
+	assertEqualInt64(t, ErrorNone, int64(d.Error))
+
+	// Values that are skipped are checked too.
+	d.MaxLength = 2
+	d.Init(buf)
+	assertEqualBool(t, false, d.Field("d"))
+	assertEqualInt64(t, ErrorLengthTooLarge, int64(d.Error))
+
+	// Field names.
+	d.MaxLength = 1
+	d.Init(buf)
+	d.Field("a")
+	d.GoIntoObject()
+	assertEqualBool(t, false, d.NextField())
+	assertEqualInt64(t, ErrorLengthTooLarge, int64(d.Error))
+
+	// {"a":<string of length 2^31-1>}, truncated. The max length is
+	// checked before the input length.
+	buf = []byte("\x40\x14\x01\x61\x16\xff\xff\xff\x7f\x78\x41")
+	d = Decoder{}
+	d.Init(buf)
+	d.Field("a")
+	assertEqualInt64(t, ErrorEOF, int64(d.Error))
+
+	d.MaxLength = 100
+	d.Init(buf)
+	d.Field("a")
+	assertEqualInt64(t, ErrorLengthTooLarge, int64(d.Error))
+}
+
 // Helper functions for tests.
 
 func newEncoderFromBytes(buf []byte) Encoder {
@@ -0,0 +1,395 @@
+// Package binsonhttp has helpers for serving and requesting Binson
+// over HTTP, with the media type application/binson.
+//
+// Responses can also be sent as JSON, if the client prefers
+// application/json in its Accept header. Clients that accept neither
+// get 406 Not Acceptable.
+//
+// Bodies are read into a new slice, marshaling allocates a buffer of
+// the max size, and ToJSON builds the JSON text in a bytes.Buffer.
+package binsonhttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// Media types.
+const (
+	MediaType     = "application/binson"
+	JSONMediaType = "application/json"
+)
+
+// DefaultMaxSize is the max body size used when 0 is given.
+const DefaultMaxSize = 64 * 1024
+
+// Errors returned when reading request and response bodies.
+var (
+	ErrTooLarge  = errors.New("binsonhttp: body too large")
+	ErrMediaType = errors.New("binsonhttp: content type is not " + MediaType)
+	ErrNotObject = errors.New("binsonhttp: body is not a Binson object")
+	ErrJSON      = errors.New("binsonhttp: value cannot be converted to JSON")
+)
+
+// ErrNotAcceptable is returned by WriteResponse when the client
+// accepts neither Binson nor JSON.
+var ErrNotAcceptable = errors.New("binsonhttp: client accepts neither " + MediaType + " nor " + JSONMediaType)
+
+// An Unmarshaler reads itself from a Binson object. d is positioned
+// before the first field of the object.
+type Unmarshaler interface {
+	UnmarshalBinson(d *binson.Decoder) error
+}
+
+// A Marshaler writes its fields to a Binson object. The object begin
+// and end are written by the caller.
+type Marshaler interface {
+	MarshalBinson(e *binson.Encoder)
+}
+
+// ======== Server side ========
+
+// ReadRequest reads the body of r, which must have the content type
+// application/binson and contain one Binson object of at most maxSize
+// bytes. 0 means DefaultMaxSize.
+func ReadRequest(r *http.Request, maxSize int) ([]byte, error) {
+	if !hasMediaType(r.Header.Get("Content-Type")) {
+		return nil, ErrMediaType
+	}
+	return readBody(r.Body, maxSize)
+}
+
+// DecodeRequest reads the body of r like ReadRequest, and initializes
+// d to decode it.
+func DecodeRequest(r *http.Request, maxSize int, d *binson.Decoder) error {
+	body, err := ReadRequest(r, maxSize)
+	if err != nil {
+		return err
+	}
+	d.Init(body)
+	return nil
+}
+
+// UnmarshalRequest reads the body of r like ReadRequest, and lets v
+// read the object.
+func UnmarshalRequest(r *http.Request, maxSize int, v Unmarshaler) error {
+	var d binson.Decoder
+	if err := DecodeRequest(r, maxSize, &d); err != nil {
+		return err
+	}
+	return unmarshal(&d, v)
+}
+
+// StatusCode returns the HTTP status code to respond with when reading
+// a request failed with err.
+func StatusCode(err error) int {
+	switch err {
+	case nil:
+		return http.StatusOK
+	case ErrTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrMediaType:
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// WriteResponse writes the Binson object obj as the response to r.
+// If the Accept header of r prefers application/json to
+// application/binson, the object is converted to JSON. If it accepts
+// neither, the response is 406 Not Acceptable and ErrNotAcceptable is
+// returned. The response has "Vary: Accept", since its format depends
+// on the header.
+func WriteResponse(w http.ResponseWriter, r *http.Request, status int, obj []byte) error {
+	w.Header().Add("Vary", "Accept")
+	accept := r.Header.Get("Accept")
+	if !Acceptable(accept) {
+		http.Error(w, ErrNotAcceptable.Error(), http.StatusNotAcceptable)
+		return ErrNotAcceptable
+	}
+
+	body := obj
+	mediaType := MediaType
+	if PreferJSON(accept) {
+		var err error
+		if body, err = ToJSON(obj); err != nil {
+			return err
+		}
+		mediaType = JSONMediaType
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+// MarshalResponse writes v as the response to r, like WriteResponse.
+// maxSize is the max size of the object, 0 means DefaultMaxSize.
+func MarshalResponse(w http.ResponseWriter, r *http.Request, status int, maxSize int, v Marshaler) error {
+	obj, err := marshal(v, maxSize)
+	if err != nil {
+		return err
+	}
+	return WriteResponse(w, r, status, obj)
+}
+
+// Acceptable returns true if an Accept header value accepts
+// application/binson or application/json. An empty value accepts both.
+func Acceptable(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	return quality(accept, MediaType) > 0 || quality(accept, JSONMediaType) > 0
+}
+
+// PreferJSON returns true if an Accept header value gives
+// application/json a higher quality than application/binson.
+// Wildcards are taken into account, Binson wins ties.
+func PreferJSON(accept string) bool {
+	if accept == "" {
+		return false
+	}
+	return quality(accept, JSONMediaType) > quality(accept, MediaType)
+}
+
+// ======== Client side ========
+
+// NewRequest returns a request with the Binson object obj as body.
+// The Accept header is set to application/binson.
+func NewRequest(method, url string, obj []byte) (*http.Request, error) {
+	var body io.Reader
+	if obj != nil {
+		body = bytes.NewReader(obj)
+	}
+	r, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if obj != nil {
+		r.Header.Set("Content-Type", MediaType)
+	}
+	r.Header.Set("Accept", MediaType)
+	return r, nil
+}
+
+// ReadResponse reads the body of resp, which must have the content type
+// application/binson and contain one Binson object of at most maxSize
+// bytes. 0 means DefaultMaxSize. The body is closed.
+func ReadResponse(resp *http.Response, maxSize int) ([]byte, error) {
+	defer resp.Body.Close()
+	if !hasMediaType(resp.Header.Get("Content-Type")) {
+		return nil, ErrMediaType
+	}
+	return readBody(resp.Body, maxSize)
+}
+
+// UnmarshalResponse reads the body of resp like ReadResponse, and lets v
+// read the object.
+func UnmarshalResponse(resp *http.Response, maxSize int, v Unmarshaler) error {
+	body, err := ReadResponse(resp, maxSize)
+	if err != nil {
+		return err
+	}
+	var d binson.Decoder
+	d.Init(body)
+	return unmarshal(&d, v)
+}
+
+// ======== JSON ========
+
+// ToJSON converts the Binson object obj to JSON. Bytes values are
+// converted to base64 strings, like encoding/json does with []byte.
+// Doubles that are NaN or infinite cannot be converted.
+func ToJSON(obj []byte) ([]byte, error) {
+	var d binson.Decoder
+	d.Init(obj)
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	if err := jsonFields(&buf, &d); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+
+	if d.Error != binson.ErrorNone || d.Offset() != len(obj) {
+		return nil, ErrNotObject
+	}
+	return buf.Bytes(), nil
+}
+
+func jsonFields(buf *bytes.Buffer, d *binson.Decoder) error {
+	for i := 0; d.NextField(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		jsonString(buf, d.Name)
+		buf.WriteByte(':')
+		if err := jsonValue(buf, d, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the last value read by d. Objects and arrays are written
+// recursively, d is then moved up to the parent, which is an array
+// if inArray is true.
+func jsonValue(buf *bytes.Buffer, d *binson.Decoder, inArray bool) error {
+	if d.Error != binson.ErrorNone {
+		return ErrNotObject
+	}
+
+	switch d.ValueType {
+	case binson.Boolean:
+		buf.WriteString(strconv.FormatBool(d.ValueBoolean))
+	case binson.Integer:
+		buf.WriteString(strconv.FormatInt(d.ValueInteger, 10))
+	case binson.Double:
+		b, err := json.Marshal(d.ValueDouble)
+		if err != nil {
+			return ErrJSON
+		}
+		buf.Write(b)
+	case binson.String:
+		jsonString(buf, d.ValueBytes)
+	case binson.Bytes:
+		buf.WriteByte('"')
+		buf.WriteString(base64.StdEncoding.EncodeToString(d.ValueBytes))
+		buf.WriteByte('"')
+	case binson.Object:
+		buf.WriteByte('{')
+		d.GoIntoObject()
+		if err := jsonFields(buf, d); err != nil {
+			return err
+		}
+		goUp(d, inArray)
+		buf.WriteByte('}')
+	case binson.Array:
+		buf.WriteByte('[')
+		d.GoIntoArray()
+		for i := 0; d.NextArrayValue(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := jsonValue(buf, d, true); err != nil {
+				return err
+			}
+		}
+		goUp(d, inArray)
+		buf.WriteByte(']')
+	}
+	return nil
+}
+
+func jsonString(buf *bytes.Buffer, s []byte) {
+	b, _ := json.Marshal(string(s)) // never fails for strings
+	buf.Write(b)
+}
+
+func goUp(d *binson.Decoder, inArray bool) {
+	if inArray {
+		d.GoUpToArray()
+	} else {
+		d.GoUpToObject()
+	}
+}
+
+// ======== Helpers ========
+
+func readBody(body io.Reader, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	b, err := io.ReadAll(io.LimitReader(body, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	if !binson.IsObject(b) {
+		return nil, ErrNotObject
+	}
+	return b, nil
+}
+
+func unmarshal(d *binson.Decoder, v Unmarshaler) error {
+	if err := v.UnmarshalBinson(d); err != nil {
+		return err
+	}
+	if d.Error != binson.ErrorNone {
+		return ErrNotObject
+	}
+	return nil
+}
+
+func marshal(v Marshaler, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	buf := make([]byte, maxSize)
+	var e binson.Encoder
+	e.Init(buf)
+	e.Begin()
+	v.MarshalBinson(&e)
+	e.End()
+	if e.Error == binson.ErrorEOF {
+		return nil, ErrTooLarge
+	}
+	if e.Error != binson.ErrorNone {
+		return nil, ErrNotObject
+	}
+	return buf[:e.Offset], nil
+}
+
+func hasMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == MediaType
+}
+
+// Returns the quality given to mediaType by an Accept header value.
+// The most specific matching range is used.
+func quality(accept string, mediaType string) float64 {
+	best, bestSpecificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		specificity := -1
+		switch {
+		case rangeType == mediaType:
+			specificity = 2
+		case rangeType == "*/*":
+			specificity = 0
+		case strings.HasSuffix(rangeType, "/*") &&
+			strings.HasPrefix(mediaType, strings.TrimSuffix(rangeType, "*")):
+			specificity = 1
+		}
+		if specificity <= bestSpecificity {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				q = 0
+			}
+		}
+		best, bestSpecificity = q, specificity
+	}
+	return best
+}
@@ -0,0 +1,228 @@
+package binsonhttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+type reading struct {
+	Sensor string
+	Value  int64
+}
+
+func (r *reading) MarshalBinson(e *binson.Encoder) {
+	e.FieldString("sensor", r.Sensor)
+	e.FieldInt("value", r.Value)
+}
+
+func (r *reading) UnmarshalBinson(d *binson.Decoder) error {
+	if !d.Field("sensor") || d.ValueType != binson.String {
+		return errors.New("sensor missing")
+	}
+	r.Sensor = string(d.ValueBytes)
+	if !d.Field("value") || d.ValueType != binson.Integer {
+		return errors.New("value missing")
+	}
+	r.Value = d.ValueInteger
+	return nil
+}
+
+// Responds with the request, with value doubled.
+func doubleHandler(w http.ResponseWriter, r *http.Request) {
+	var v reading
+	if err := UnmarshalRequest(r, 100, &v); err != nil {
+		http.Error(w, err.Error(), StatusCode(err))
+		return
+	}
+	v.Value *= 2
+	MarshalResponse(w, r, http.StatusOK, 0, &v)
+}
+
+func newReading(t *testing.T) []byte {
+	buf := make([]byte, 100)
+	var e binson.Encoder
+	e.Init(buf)
+	e.Begin()
+	(&reading{"temp", 21}).MarshalBinson(&e)
+	e.End()
+	return buf[:e.Offset]
+}
+
+func TestRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(doubleHandler))
+	defer server.Close()
+
+	req, err := NewRequest("POST", server.URL, newReading(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != MediaType {
+		t.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	var v reading
+	if err := UnmarshalResponse(resp, 0, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Sensor != "temp" || v.Value != 42 {
+		t.Errorf("unexpected response %+v", v)
+	}
+}
+
+func TestJSONResponse(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(newReading(t)))
+	req.Header.Set("Content-Type", MediaType)
+	req.Header.Set("Accept", "application/json, application/binson;q=0.5")
+	rec := httptest.NewRecorder()
+
+	doubleHandler(rec, req)
+
+	if rec.Header().Get("Content-Type") != JSONMediaType {
+		t.Errorf("unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != `{"sensor":"temp","value":42}` {
+		t.Errorf("unexpected body %s", rec.Body.String())
+	}
+	if rec.Header().Get("Vary") != "Accept" {
+		t.Errorf("unexpected Vary %q", rec.Header().Get("Vary"))
+	}
+}
+
+func TestNotAcceptable(t *testing.T) {
+	table := []struct {
+		accept string
+		status int
+	}{
+		{"", http.StatusOK},
+		{"application/binson", http.StatusOK},
+		{"application/json", http.StatusOK},
+		{"*/*", http.StatusOK},
+		{"text/html", http.StatusNotAcceptable},
+		{"text/*, application/binson;q=0", http.StatusNotAcceptable},
+		{"*/*;q=0", http.StatusNotAcceptable},
+	}
+
+	for _, record := range table {
+		req := httptest.NewRequest("GET", "/", nil)
+		if record.accept != "" {
+			req.Header.Set("Accept", record.accept)
+		}
+		rec := httptest.NewRecorder()
+		err := WriteResponse(rec, req, http.StatusOK, newReading(t))
+		if rec.Code != record.status {
+			t.Errorf("Accept %q: expected status %d, got %d", record.accept, record.status, rec.Code)
+		}
+		if (record.status == http.StatusNotAcceptable) != (err == ErrNotAcceptable) {
+			t.Errorf("Accept %q: unexpected error %v", record.accept, err)
+		}
+		if rec.Header().Get("Vary") != "Accept" {
+			t.Errorf("Accept %q: unexpected Vary %q", record.accept, rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	table := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"text/plain", "\x40\x41", http.StatusUnsupportedMediaType},
+		{MediaType, "\x40\x41\x41", http.StatusBadRequest},
+		{MediaType, "\x40\x14\x01\x61", http.StatusBadRequest},
+		{MediaType, "\x40\x41", http.StatusBadRequest}, // no fields
+		{MediaType, "\x40\x14\x01\x61\x18\x64" + strings.Repeat("x", 100) + "\x41", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, record := range table {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(record.body))
+		req.Header.Set("Content-Type", record.contentType)
+		rec := httptest.NewRecorder()
+		doubleHandler(rec, req)
+		if rec.Code != record.status {
+			t.Errorf("body %x: expected status %d, got %d", record.body, record.status, rec.Code)
+		}
+	}
+}
+
+func TestPreferJSON(t *testing.T) {
+	table := []struct {
+		accept string
+		json   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"application/binson", false},
+		{"application/json, application/binson", false},
+		{"application/json;q=0.9, application/binson;q=0.8", true},
+		{"application/*, application/json;q=0.1", false},
+		{"application/json, */*;q=0.1", true},
+		{"text/html", false},
+	}
+
+	for _, record := range table {
+		if PreferJSON(record.accept) != record.json {
+			t.Errorf("Accept %q: expected %v", record.accept, record.json)
+		}
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	// {"a":[true,1.5,"x\"y",{}],"b":"0x0102ff","c":{"d":-1}}
+	buf := make([]byte, 100)
+	var e binson.Encoder
+	e.Init(buf)
+	e.Begin()
+	e.FieldBeginArray("a")
+	e.Bool(true)
+	e.Double(1.5)
+	e.String("x\"y")
+	e.Begin()
+	e.End()
+	e.EndArray()
+	e.FieldBytes("b", []byte{1, 2, 0xff})
+	e.FieldBeginObject("c")
+	e.FieldInt("d", -1)
+	e.End()
+	e.End()
+
+	j, err := ToJSON(buf[:e.Offset])
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"a":[true,1.5,"x\"y",{}],"b":"AQL/","c":{"d":-1}}`
+	if string(j) != exp {
+		t.Errorf("expected %s, got %s", exp, j)
+	}
+
+	var zero float64
+	e.Init(buf)
+	e.Begin()
+	e.FieldDouble("a", zero/zero)
+	e.End()
+	if _, err := ToJSON(buf[:e.Offset]); err != ErrJSON {
+		t.Errorf("expected ErrJSON, got %v", err)
+	}
+}
+
+func TestReadResponseMediaType(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": {"text/plain"}},
+		Body:   io.NopCloser(strings.NewReader("\x40\x41")),
+	}
+	if _, err := ReadResponse(resp, 0); err != ErrMediaType {
+		t.Errorf("expected ErrMediaType, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+// Runs the conformance vectors in testdata/vectors.json, see
+// testdata/README.md for the format.
+// Note, like the examples, this test is not executed with "tinygo test".
+
+package binson_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+	"github.com/assaabloy-ppi/binson-go-tiny/binson/text"
+)
+
+type vector struct {
+	Name      string
+	Hex       string
+	Value     string
+	Error     string
+	Canonical string
+	MaxLength int `json:"max_length"`
+}
+
+// The binson error code of each error category.
+var errorCodes = map[string]int{
+	"eof":              binson.ErrorEOF,
+	"not_object":       binson.ErrorExpectedBegin,
+	"unknown_type":     binson.ErrorUnexpectedTypeByte,
+	"name_not_string":  binson.ErrorUnexpectedType,
+	"negative_length":  binson.ErrorNegativeLength,
+	"length_too_large": binson.ErrorLengthTooLarge,
+	"invalid_utf8":     binson.ErrorInvalidUTF8,
+	"not_shortest":     binson.ErrorNotShortest,
+	"field_order":      binson.ErrorFieldOrder,
+	"duplicate_name":   binson.ErrorDuplicateName,
+	"too_deep":         binson.ErrorTooDeep,
+	"trailing_bytes":   binson.ErrorTrailingBytes,
+}
+
+// Returns true if a lenient decoder reads the vector without errors.
+func (v *vector) lenient() bool {
+	switch v.Error {
+	case "", "invalid_utf8", "not_shortest", "field_order", "duplicate_name", "too_deep":
+		return true
+	}
+	return false
+}
+
+func readVectors(t *testing.T) []vector {
+	data, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []vector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	return vectors
+}
+
+func TestConformance(t *testing.T) {
+	for _, v := range readVectors(t) {
+		buf, err := hex.DecodeString(v.Hex)
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+
+		expError := binson.ErrorNone
+		if v.Error != "" {
+			code, ok := errorCodes[v.Error]
+			if !ok {
+				t.Fatalf("%s: unknown error %s", v.Name, v.Error)
+			}
+			expError = code
+		}
+
+		// The max length is a decoder setting, the other checks are
+		// only made when it is not exceeded.
+		if v.MaxLength > 0 {
+			d := binson.Decoder{MaxLength: v.MaxLength}
+			d.Init(buf)
+			d.Consumed()
+			if d.Error != expError {
+				t.Errorf("%s: decoder error %d, expected %d", v.Name, d.Error, expError)
+			}
+			if expError != binson.ErrorNone {
+				continue
+			}
+		}
+
+		if got := binson.Validate(buf); got != expError {
+			t.Errorf("%s: Validate returned %d, expected %d", v.Name, got, expError)
+		}
+
+		// Decoder, through the text notation.
+		formatted := text.Format(buf)
+		switch {
+		case expError == binson.ErrorNone && formatted != v.Value:
+			t.Errorf("%s: decoded as\n%s\nexpected\n%s", v.Name, formatted, v.Value)
+		case strings.Contains(formatted, "<error") == v.lenient():
+			t.Errorf("%s: decoded as %s", v.Name, formatted)
+		}
+
+		// Encoder, the expected value of valid vectors must be encoded
+		// as the input.
+		if expError == binson.ErrorNone {
+			out := make([]byte, len(buf))
+			e := binson.Encoder{}
+			e.Init(out)
+			if err := text.Parse(v.Value, &e); err != nil {
+				t.Errorf("%s: %v", v.Name, err)
+			} else if !bytes.Equal(out[:e.Offset], buf) {
+				t.Errorf("%s: encoded as %x", v.Name, out[:e.Offset])
+			}
+		}
+
+		// Canonical form.
+		dst := make([]byte, len(buf))
+		n, code := binson.Canonicalize(dst, buf)
+		switch {
+		case expError == binson.ErrorNone:
+			if code != binson.ErrorNone || !bytes.Equal(dst[:n], buf) {
+				t.Errorf("%s: Canonicalize changed a canonical object, error %d", v.Name, code)
+			}
+		case v.Canonical != "":
+			if got := hex.EncodeToString(dst[:n]); code != binson.ErrorNone || got != v.Canonical {
+				t.Errorf("%s: canonical form %s, error %d, expected %s", v.Name, got, code, v.Canonical)
+			}
+		case code == binson.ErrorNone:
+			t.Errorf("%s: Canonicalize did not fail", v.Name)
+		}
+	}
+}
+
+// Cuts every vector that a lenient decoder can read at every length.
+// Every cut must give ErrorEOF, and never a panic or another error.
+func TestTruncation(t *testing.T) {
+	for _, v := range readVectors(t) {
+		if !v.lenient() {
+			continue
+		}
+		buf, err := hex.DecodeString(v.Hex)
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+
+		for n := 0; n < len(buf); n++ {
+			cut := buf[:n]
+			if got := text.Format(cut); !strings.HasSuffix(got, "<error 1>") {
+				t.Errorf("%s, %d bytes: decoded as %s", v.Name, n, got)
+			}
+
+			d := binson.Decoder{}
+			d.Init(cut)
+			d.Consumed()
+			if d.Error != binson.ErrorEOF {
+				t.Errorf("%s, %d bytes: Consumed error %d", v.Name, n, d.Error)
+			}
+
+			it := binson.Objects(cut)
+			for it.Next() {
+			}
+			if n > 0 && it.Error != binson.ErrorEOF {
+				t.Errorf("%s, %d bytes: Objects error %d", v.Name, n, it.Error)
+			}
+
+			// Other errors may be found before the end of invalid vectors.
+			if v.Error != "" {
+				continue
+			}
+			if got := binson.Validate(cut); got != binson.ErrorEOF {
+				t.Errorf("%s, %d bytes: Validate error %d", v.Name, n, got)
+			}
+			if _, got := binson.Canonicalize(make([]byte, len(buf)), cut); got != binson.ErrorEOF {
+				t.Errorf("%s, %d bytes: Canonicalize error %d", v.Name, n, got)
+			}
+			if _, got := binson.Merge(make([]byte, len(buf)), cut, []byte("\x40\x41")); got != binson.ErrorEOF {
+				t.Errorf("%s, %d bytes: Merge error %d", v.Name, n, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package diff computes the structural differences between two
+// Binson objects.
+//
+// Both objects are walked in lockstep with binson.Decoder. Since the
+// fields of Binson objects are sorted, a field that is only in one of
+// the objects is found by comparing the current field names, just like
+// merging two sorted lists. Array elements are compared by index.
+//
+// The changes are returned in a new slice, and their paths and values
+// are new strings.
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+	"github.com/assaabloy-ppi/binson-go-tiny/binson/text"
+)
+
+// A Kind is a kind of change.
+type Kind int
+
+// Kinds of changes.
+const (
+	Added        Kind = iota // the value is only in the new object
+	Removed                  // the value is only in the old object
+	TypeChanged              // the values have different types
+	ValueChanged             // the values have the same type, but differ
+)
+
+var kindNames = [...]string{
+	Added:        "added",
+	Removed:      "removed",
+	TypeChanged:  "type changed",
+	ValueChanged: "value changed",
+}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind " + strconv.Itoa(int(k))
+}
+
+// A Change is a difference between the old and the new object. Path is
+// the location of the value, such as "pos.lat" or "tags[2]". Old and New
+// are the values in the text notation of package text, "" for a value
+// that is missing.
+// Nested objects and arrays are only compared when both values are
+// objects or arrays, otherwise they are formatted in full.
+type Change struct {
+	Kind Kind
+	Path string
+	Old  string
+	New  string
+}
+
+func (c Change) String() string {
+	s := c.Kind.String() + " " + c.Path + ": "
+	switch c.Kind {
+	case Added:
+		return s + c.New
+	case Removed:
+		return s + c.Old
+	}
+	return s + c.Old + " -> " + c.New
+}
+
+// Errors returned by Diff.
+var (
+	ErrNotObject = errors.New("diff: input is not a Binson object")
+	ErrNotSorted = errors.New("diff: fields are not sorted")
+)
+
+// Diff returns the changes from the Binson object old to new, in the
+// order of the fields. Returns nil if the objects are equal.
+// The fields of both objects must be sorted.
+func Diff(old, new []byte) ([]Change, error) {
+	df := differ{}
+	df.old.Init(old)
+	df.new.Init(new)
+
+	df.objects("")
+	if df.err != nil {
+		return nil, df.err
+	}
+	if df.old.Error != binson.ErrorNone || df.new.Error != binson.ErrorNone ||
+		df.old.Offset() != len(old) || df.new.Offset() != len(new) {
+		return nil, ErrNotObject
+	}
+	return df.changes, nil
+}
+
+type differ struct {
+	old, new binson.Decoder
+	changes  []Change
+	err      error
+}
+
+func (df *differ) add(kind Kind, path, old, new string) {
+	df.changes = append(df.changes, Change{Kind: kind, Path: path, Old: old, New: new})
+}
+
+// Returns false, and sets df.err, if a decoder has failed.
+func (df *differ) ok() bool {
+	if df.err == nil && (df.old.Error != binson.ErrorNone || df.new.Error != binson.ErrorNone) {
+		df.err = ErrNotObject
+	}
+	return df.err == nil
+}
+
+// Compares the fields of the objects that both decoders are in, up to
+// the end of the objects.
+func (df *differ) objects(path string) {
+	var lastOld, lastNew []byte
+	hasOld, hasNew := df.nextField(&df.old, &lastOld), df.nextField(&df.new, &lastNew)
+
+	for (hasOld || hasNew) && df.ok() {
+		cmp := 0
+		switch {
+		case !hasNew:
+			cmp = -1
+		case !hasOld:
+			cmp = 1
+		default:
+			cmp = bytes.Compare(df.old.Name, df.new.Name)
+		}
+
+		switch {
+		case cmp < 0:
+			fieldPath := joinName(path, string(df.old.Name))
+			df.add(Removed, fieldPath, formatValue(&df.old, false), "")
+			hasOld = df.nextField(&df.old, &lastOld)
+		case cmp > 0:
+			fieldPath := joinName(path, string(df.new.Name))
+			df.add(Added, fieldPath, "", formatValue(&df.new, false))
+			hasNew = df.nextField(&df.new, &lastNew)
+		default:
+			df.values(joinName(path, string(df.old.Name)), false)
+			hasOld = df.nextField(&df.old, &lastOld)
+			hasNew = df.nextField(&df.new, &lastNew)
+		}
+	}
+}
+
+// Reads the next field with d, and checks that its name is after last.
+func (df *differ) nextField(d *binson.Decoder, last *[]byte) bool {
+	if !d.NextField() {
+		return false
+	}
+	if *last != nil && bytes.Compare(*last, d.Name) >= 0 {
+		if df.err == nil {
+			df.err = ErrNotSorted
+		}
+		return false
+	}
+	*last = d.Name
+	return true
+}
+
+// Compares the last values read by both decoders. Objects and arrays
+// are compared recursively, the decoders are then moved up to the
+// parent, which is an array if inArray is true.
+func (df *differ) values(path string, inArray bool) {
+	o, n := &df.old, &df.new
+	if !df.ok() {
+		return
+	}
+	if o.ValueType != n.ValueType {
+		df.add(TypeChanged, path, formatValue(o, inArray), formatValue(n, inArray))
+		return
+	}
+
+	equal := true
+	switch o.ValueType {
+	case binson.Boolean:
+		equal = o.ValueBoolean == n.ValueBoolean
+	case binson.Integer:
+		equal = o.ValueInteger == n.ValueInteger
+	case binson.Double:
+		// Bitwise, so that NaN equals NaN and 0.0 differs from -0.0.
+		equal = math.Float64bits(o.ValueDouble) == math.Float64bits(n.ValueDouble)
+	case binson.String, binson.Bytes:
+		equal = bytes.Equal(o.ValueBytes, n.ValueBytes)
+	case binson.Object:
+		o.GoIntoObject()
+		n.GoIntoObject()
+		df.objects(path)
+		df.goUp(inArray)
+	case binson.Array:
+		o.GoIntoArray()
+		n.GoIntoArray()
+		df.arrays(path)
+		df.goUp(inArray)
+	}
+	if !equal {
+		df.add(ValueChanged, path, formatValue(o, inArray), formatValue(n, inArray))
+	}
+}
+
+// Compares the elements of the arrays that both decoders are in, up to
+// the end of the arrays.
+func (df *differ) arrays(path string) {
+	hasOld, hasNew := df.old.NextArrayValue(), df.new.NextArrayValue()
+	for i := 0; (hasOld || hasNew) && df.ok(); i++ {
+		elemPath := path + "[" + strconv.Itoa(i) + "]"
+		switch {
+		case !hasNew:
+			df.add(Removed, elemPath, formatValue(&df.old, true), "")
+			hasOld = df.old.NextArrayValue()
+		case !hasOld:
+			df.add(Added, elemPath, "", formatValue(&df.new, true))
+			hasNew = df.new.NextArrayValue()
+		default:
+			df.values(elemPath, true)
+			hasOld = df.old.NextArrayValue()
+			hasNew = df.new.NextArrayValue()
+		}
+	}
+}
+
+func (df *differ) goUp(inArray bool) {
+	if !df.ok() {
+		return
+	}
+	goUp(&df.old, inArray)
+	goUp(&df.new, inArray)
+}
+
+func goUp(d *binson.Decoder, inArray bool) {
+	if inArray {
+		d.GoUpToArray()
+	} else {
+		d.GoUpToObject()
+	}
+}
+
+func joinName(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// ======== Formatting ========
+
+// Returns the last value read by d in text form, see package text.
+// For objects and arrays, d is then moved up to the parent, which is
+// an array if inArray is true.
+func formatValue(d *binson.Decoder, inArray bool) string {
+	container := d.ValueType == binson.Object || d.ValueType == binson.Array
+	s := text.FormatValue(d)
+	if container && d.Error == binson.ErrorNone {
+		goUp(d, inArray)
+	}
+	return s
+}
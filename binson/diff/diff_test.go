@@ -0,0 +1,146 @@
+package diff
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+func encode(f func(e *binson.Encoder)) []byte {
+	buf := make([]byte, 500)
+	var e binson.Encoder
+	e.Init(buf)
+	e.Begin()
+	f(&e)
+	e.End()
+	return buf[:e.Offset]
+}
+
+func changeStrings(changes []Change) []string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.String())
+	}
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	old := encode(func(e *binson.Encoder) {
+		e.FieldBytes("b", []byte{1, 2})
+		e.FieldInt("id", 1)
+		e.FieldBeginArray("list")
+		e.Integer(1)
+		e.Integer(2)
+		e.Integer(3)
+		e.EndArray()
+		e.FieldString("name", "a")
+		e.FieldBeginObject("pos")
+		e.FieldDouble("lat", 1.5)
+		e.FieldDouble("lon", 2)
+		e.End()
+		e.FieldBool("x", true)
+	})
+	new := encode(func(e *binson.Encoder) {
+		e.FieldBytes("b", []byte{1, 3})
+		e.FieldInt("id", 1)
+		e.FieldBeginArray("list")
+		e.Integer(1)
+		e.String("2")
+		e.EndArray()
+		e.FieldBeginObject("meta")
+		e.FieldBeginArray("a")
+		e.Bool(false)
+		e.EndArray()
+		e.End()
+		e.FieldBeginObject("pos")
+		e.FieldInt("alt", 10)
+		e.FieldDouble("lat", 1.5)
+		e.FieldDouble("lon", math.Inf(-1))
+		e.End()
+		e.FieldBeginArray("x")
+		e.EndArray()
+	})
+
+	changes, err := Diff(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		`value changed b: 0x0102 -> 0x0103`,
+		`type changed list[1]: 2 -> "2"`,
+		`removed list[2]: 3`,
+		`added meta: {"a": [false]}`,
+		`removed name: "a"`,
+		`added pos.alt: 10`,
+		`value changed pos.lon: 2.0 -> -Inf`,
+		`type changed x: true -> []`,
+	}
+	if got := changeStrings(changes); !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected changes\n%q\nexpected\n%q", got, exp)
+	}
+	if changes[0].Kind != ValueChanged || changes[0].Path != "b" || changes[0].Old != "0x0102" {
+		t.Errorf("unexpected first change %+v", changes[0])
+	}
+
+	// Reversed, added and removed swap.
+	changes, err = Diff(new, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(exp) || changes[2].Kind != Added || changes[3].Kind != Removed {
+		t.Errorf("unexpected reversed changes %q", changeStrings(changes))
+	}
+}
+
+func TestDiffEqual(t *testing.T) {
+	nan := math.NaN()
+	obj := encode(func(e *binson.Encoder) {
+		e.FieldBeginArray("a")
+		e.Begin()
+		e.FieldDouble("n", nan)
+		e.End()
+		e.EndArray()
+		e.FieldInt("b", 1)
+	})
+	changes, err := Diff(obj, obj)
+	if err != nil || changes != nil {
+		t.Errorf("expected no changes, got %v, %v", changes, err)
+	}
+
+	empty := encode(func(e *binson.Encoder) {})
+	changes, err = Diff(empty, obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{`added a: [{"n": NaN}]`, `added b: 1`}
+	if got := changeStrings(changes); !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected changes %q", got)
+	}
+}
+
+func TestDiffErrors(t *testing.T) {
+	valid := encode(func(e *binson.Encoder) { e.FieldInt("a", 1) })
+	unsorted := encode(func(e *binson.Encoder) {
+		e.FieldInt("b", 1)
+		e.FieldInt("a", 1)
+	})
+
+	table := []struct {
+		old, new []byte
+		err      error
+	}{
+		{valid, unsorted, ErrNotSorted},
+		{unsorted, valid, ErrNotSorted},
+		{valid, valid[:len(valid)-1], ErrNotObject},
+		{append(valid, 0x41), valid, ErrNotObject},
+		{[]byte{0x40, 0x14, 0x01, 0x61, 0xff, 0x41}, valid, ErrNotObject},
+	}
+
+	for _, record := range table {
+		if _, err := Diff(record.old, record.new); err != record.err {
+			t.Errorf("%x, %x: expected %v, got %v", record.old, record.new, record.err, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !binson_portable && wasm && !tinygo
+
+package binson
+
+import "unsafe"
+
+// ======== Instead of binary, fast ========
+// Code in this section removes dependency on binary package.
+// Little-endian encoding is assumed. As used by Binson.
+//
+// This is the fast version for WebAssembly with the gc compiler. Each
+// conversion is one unsafe load or store of the whole integer, the
+// input has no alignment. The early bounds checks keep the accesses
+// inside b.
+//
+// Elsewhere the portable version in endian_portable.go is used: gc
+// merges its byte loads and stores into single ones on amd64, 386,
+// arm64 and ppc64le, but not on wasm. Set the build tag binson_portable
+// to use the portable version on wasm too.
+
+func getUint64(b []byte) uint64 {
+	_ = b[7] // early bounds check
+	return *(*uint64)(unsafe.Pointer(&b[0]))
+}
+
+func putUint64(b []byte, v uint64) {
+	_ = b[7] // early bounds check
+	*(*uint64)(unsafe.Pointer(&b[0])) = v
+}
+
+func getUint32(b []byte) uint32 {
+	_ = b[3] // early bounds check
+	return *(*uint32)(unsafe.Pointer(&b[0]))
+}
+
+func putUint32(b []byte, v uint32) {
+	_ = b[3] // early bounds check
+	*(*uint32)(unsafe.Pointer(&b[0])) = v
+}
+
+func getUint16(b []byte) uint16 {
+	_ = b[1] // early bounds check
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+func putUint16(b []byte, v uint16) {
+	_ = b[1] // early bounds check
+	*(*uint16)(unsafe.Pointer(&b[0])) = v
+}
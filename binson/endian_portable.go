@@ -0,0 +1,54 @@
+//go:build binson_portable || !wasm || tinygo
+
+package binson
+
+// ======== Instead of binary, portable ========
+// Code in this section removes dependency on binary package.
+// Little-endian encoding is assumed. As used by Binson.
+// Early bounds checks (decreasing indexes) see code in binary package and
+// golang.org/issue/14808. Can improve performance. Check TinyGo performance.
+//
+// This is the portable version, byte by byte. It is used everywhere
+// except on wasm with the gc compiler, see endian_fast.go.
+
+func getUint64(b []byte) uint64 {
+	_ = b[7] // early bounds check for performance
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+}
+
+func putUint64(b []byte, v uint64) {
+	_ = b[7] // early bounds check
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+	b[3] = byte(v >> 24)
+	b[4] = byte(v >> 32)
+	b[5] = byte(v >> 40)
+	b[6] = byte(v >> 48)
+	b[7] = byte(v >> 56)
+}
+
+func getUint32(b []byte) uint32 {
+	_ = b[3] // early bounds check see golang.org/issue/14808
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func putUint32(b []byte, v uint32) {
+	_ = b[3] // early bounds check, golang.org/issue/14808
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+	b[3] = byte(v >> 24)
+}
+
+func getUint16(b []byte) uint16 {
+	_ = b[1] // early bounds check
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func putUint16(b []byte, v uint16) {
+	_ = b[1] // early bounds check
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+}
@@ -6,7 +6,7 @@ package binson
 
 import "fmt"
 
-func Example1() {
+func Example_integer() {
 	//
 	// {"a":123, "s":"Hello world!"}
 	//
@@ -30,7 +30,7 @@ func Example1() {
 	// Output: 123
 }
 
-func Example2() {
+func Example_string() {
 	//
 	// {"a":123, "s":"Hello world!"}
 	//
@@ -0,0 +1,221 @@
+// Package frame delimits Binson objects sent over byte streams,
+// such as UART and TCP links.
+//
+// Each object is sent as a frame with a header of a two byte magic
+// value and the length of the object:
+//
+//	+------+------+-----------------------+----------------+
+//	| 0xb1 | 0x5e | length, uint32 LE     | Binson object  |
+//	+------+------+-----------------------+----------------+
+//
+// A FrameReader checks the length against its max frame size and that
+// the content is a Binson object. If not, the frame is reported as
+// corrupted and the reader resynchronizes by searching for the next
+// magic value, starting at the byte after the magic of the corrupted
+// frame.
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// Magic is the two bytes that start each frame.
+var Magic = [2]byte{0xb1, 0x5e}
+
+// HeaderSize is the number of bytes before the object in a frame.
+const HeaderSize = 6
+
+// DefaultMaxSize is the max object size used when 0 is given
+// as the max size.
+const DefaultMaxSize = 64 * 1024
+
+// Errors returned by FrameWriter and FrameReader.
+var (
+	ErrTooLarge = errors.New("frame: object larger than max frame size")
+	ErrCorrupt  = errors.New("frame: corrupted frame")
+)
+
+// ======== FrameWriter ========
+
+// A FrameWriter writes Binson objects as frames to an io.Writer.
+type FrameWriter struct {
+	w       io.Writer
+	maxSize int
+	header  [HeaderSize]byte
+}
+
+// NewFrameWriter returns a FrameWriter that writes to w. Objects larger
+// than maxSize bytes are rejected, 0 means DefaultMaxSize.
+func NewFrameWriter(w io.Writer, maxSize int) *FrameWriter {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &FrameWriter{w: w, maxSize: maxSize}
+}
+
+// WriteFrame writes the Binson object obj as one frame.
+// The object is not checked, except for its size.
+func (fw *FrameWriter) WriteFrame(obj []byte) error {
+	if len(obj) > fw.maxSize {
+		return ErrTooLarge
+	}
+
+	fw.header[0] = Magic[0]
+	fw.header[1] = Magic[1]
+	binary.LittleEndian.PutUint32(fw.header[2:], uint32(len(obj)))
+	if _, err := fw.w.Write(fw.header[:]); err != nil {
+		return err
+	}
+	_, err := fw.w.Write(obj)
+	return err
+}
+
+// ======== FrameReader ========
+
+// A FrameReader reads frames from an io.Reader. A buffer for one max
+// size frame is allocated when the reader is created, no memory is
+// allocated per frame.
+type FrameReader struct {
+	r       io.Reader
+	maxSize int
+	buf     []byte
+	start   int // start of unread data in buf
+	end     int // end of unread data in buf
+
+	// Skipped is the total number of bytes skipped while searching
+	// for frames, including the bytes of corrupted frames.
+	Skipped int
+}
+
+// NewFrameReader returns a FrameReader that reads from r. Frames with
+// objects larger than maxSize bytes are corrupted, 0 means
+// DefaultMaxSize.
+func NewFrameReader(r io.Reader, maxSize int) *FrameReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &FrameReader{
+		r:       r,
+		maxSize: maxSize,
+		buf:     make([]byte, HeaderSize+maxSize),
+	}
+}
+
+// ReadFrame reads the next frame and returns the Binson object in it.
+// The returned slice is only valid until the next call to ReadFrame.
+// Returns ErrCorrupt if a corrupted frame was found, the next call
+// continues with the data after the magic of that frame.
+// Returns io.EOF at the end of the input, or io.ErrUnexpectedEOF if the
+// input ends within a frame. Since the length of the frame may be
+// corrupted, ErrCorrupt is returned instead if there is another magic
+// after the magic of the frame.
+func (fr *FrameReader) ReadFrame() ([]byte, error) {
+	if err := fr.findMagic(); err != nil {
+		return nil, err
+	}
+
+	if err := fr.fill(HeaderSize); err != nil {
+		return nil, fr.endWithinFrame(err)
+	}
+	length := int(binary.LittleEndian.Uint32(fr.buf[fr.start+2:]))
+	if length > fr.maxSize || length < 2 {
+		fr.skip(1)
+		return nil, ErrCorrupt
+	}
+
+	if err := fr.fill(HeaderSize + length); err != nil {
+		return nil, fr.endWithinFrame(err)
+	}
+	obj := fr.buf[fr.start+HeaderSize : fr.start+HeaderSize+length]
+	if !binson.IsObject(obj) {
+		fr.skip(1)
+		return nil, ErrCorrupt
+	}
+
+	fr.start += HeaderSize + length
+	return obj, nil
+}
+
+// Skips data until the magic is first in the buffer.
+func (fr *FrameReader) findMagic() error {
+	for {
+		for i := fr.start; i+1 < fr.end; i++ {
+			if fr.buf[i] == Magic[0] && fr.buf[i+1] == Magic[1] {
+				fr.skip(i - fr.start)
+				return nil
+			}
+		}
+
+		// Keep a last byte that may be the start of the magic.
+		n := fr.end - fr.start
+		if n > 0 && fr.buf[fr.end-1] == Magic[0] {
+			n--
+		}
+		fr.skip(n)
+
+		if err := fr.fill(fr.end - fr.start + 1); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				fr.skip(fr.end - fr.start)
+				return io.EOF
+			}
+			return err
+		}
+	}
+}
+
+// Handles err from fill within the frame at the start of the buffer.
+// At the end of the input, the data after the magic is searched for
+// another frame. If there is one, the frame is corrupted, otherwise
+// the input is truncated and the data is skipped.
+func (fr *FrameReader) endWithinFrame(err error) error {
+	if err != io.ErrUnexpectedEOF {
+		return err
+	}
+	fr.skip(1)
+	for i := fr.start; i+1 < fr.end; i++ {
+		if fr.buf[i] == Magic[0] && fr.buf[i+1] == Magic[1] {
+			return ErrCorrupt
+		}
+	}
+	fr.skip(fr.end - fr.start)
+	return io.ErrUnexpectedEOF
+}
+
+func (fr *FrameReader) skip(n int) {
+	fr.start += n
+	fr.Skipped += n
+}
+
+// Reads until at least n bytes are available in the buffer.
+func (fr *FrameReader) fill(n int) error {
+	if fr.end-fr.start >= n {
+		return nil
+	}
+	if fr.start+n > len(fr.buf) {
+		copy(fr.buf, fr.buf[fr.start:fr.end])
+		fr.end -= fr.start
+		fr.start = 0
+	}
+
+	for fr.end-fr.start < n {
+		m, err := fr.r.Read(fr.buf[fr.end:])
+		fr.end += m
+		if err == io.EOF {
+			if fr.end-fr.start >= n {
+				return nil
+			}
+			if fr.end == fr.start {
+				return io.EOF
+			}
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
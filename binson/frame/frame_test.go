@@ -0,0 +1,164 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+var objects = [][]byte{
+	[]byte("\x40\x41"),                                     // {}
+	[]byte("\x40\x14\x01\x61\x10\x01\x41"),                 // {"a":1}
+	[]byte("\x40\x14\x01\x62\x42\x10\x0a\x10\x14\x43\x41"), // {"b":[10,20]}
+}
+
+func writeFrames(t *testing.T, w io.Writer) {
+	fw := NewFrameWriter(w, 0)
+	for _, obj := range objects {
+		if err := fw.WriteFrame(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectFrame(t *testing.T, fr *FrameReader, exp []byte) {
+	t.Helper()
+	obj, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(exp, obj) {
+		t.Errorf("expected %x, got %x", exp, obj)
+	}
+}
+
+func expectError(t *testing.T, fr *FrameReader, exp error) {
+	t.Helper()
+	_, err := fr.ReadFrame()
+	if err != exp {
+		t.Fatalf("expected error %v, got %v", exp, err)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrames(t, &buf)
+
+	exp := []byte("\xb1\x5e\x02\x00\x00\x00\x40\x41")
+	if !bytes.Equal(exp, buf.Bytes()[:len(exp)]) {
+		t.Errorf("expected %x, got %x", exp, buf.Bytes()[:len(exp)])
+	}
+
+	fr := NewFrameReader(iotest.OneByteReader(&buf), 0)
+	for _, obj := range objects {
+		expectFrame(t, fr, obj)
+	}
+	expectError(t, fr, io.EOF)
+	if fr.Skipped != 0 {
+		t.Errorf("expected no skipped bytes, got %d", fr.Skipped)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	var buf bytes.Buffer
+	fw := NewFrameWriter(&buf, 6)
+	if err := fw.WriteFrame(objects[1]); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	writeFrames(t, &buf)
+	fr := NewFrameReader(&buf, 6)
+	expectFrame(t, fr, objects[0])
+	expectError(t, fr, ErrCorrupt)
+	expectError(t, fr, ErrCorrupt)
+	expectError(t, fr, io.EOF)
+}
+
+func TestResyncAfterGarbage(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("\x00\xb1\xb1\x41")
+	writeFrames(t, &buf)
+
+	fr := NewFrameReader(&buf, 0)
+	for _, obj := range objects {
+		expectFrame(t, fr, obj)
+	}
+	expectError(t, fr, io.EOF)
+	if fr.Skipped != 4 {
+		t.Errorf("expected 4 skipped bytes, got %d", fr.Skipped)
+	}
+}
+
+func TestResyncAfterCorruptedFrame(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrames(t, &buf)
+	data := buf.Bytes()
+
+	// Corrupt the object in the second frame. The third frame
+	// starts within the second frame according to its length.
+	data[8+6+4] = 0xff
+	data[8+2] = 17
+
+	fr := NewFrameReader(bytes.NewReader(data), 0)
+	expectFrame(t, fr, objects[0])
+	expectError(t, fr, ErrCorrupt)
+	expectFrame(t, fr, objects[2])
+	expectError(t, fr, io.EOF)
+
+	// Corrupt the length of the second frame to point past the end
+	// of the input. The third frame is found after the end is reached.
+	buf.Reset()
+	writeFrames(t, &buf)
+	data = buf.Bytes()
+	data[8+2] = 0xff
+
+	fr = NewFrameReader(iotest.OneByteReader(bytes.NewReader(data)), 0)
+	expectFrame(t, fr, objects[0])
+	expectError(t, fr, ErrCorrupt)
+	expectFrame(t, fr, objects[2])
+	expectError(t, fr, io.EOF)
+}
+
+func TestTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrames(t, &buf)
+	data := buf.Bytes()
+
+	fr := NewFrameReader(bytes.NewReader(data[:len(data)-1]), 0)
+	expectFrame(t, fr, objects[0])
+	expectFrame(t, fr, objects[1])
+	expectError(t, fr, io.ErrUnexpectedEOF)
+	expectError(t, fr, io.EOF)
+
+	// The input ends within the header.
+	fr = NewFrameReader(bytes.NewReader(data[:8+4]), 0)
+	expectFrame(t, fr, objects[0])
+	expectError(t, fr, io.ErrUnexpectedEOF)
+	expectError(t, fr, io.EOF)
+}
+
+func TestLargeFrames(t *testing.T) {
+	obj := make([]byte, 100000)
+	obj[0] = 0x40
+	obj[1] = 0x14 // "" : "0x..."
+	obj[2] = 0x00
+	obj[3] = 0x1a
+	binary.LittleEndian.PutUint32(obj[4:], uint32(len(obj)-9))
+	obj[len(obj)-1] = 0x41
+
+	var buf bytes.Buffer
+	fw := NewFrameWriter(&buf, len(obj))
+	for i := 0; i < 3; i++ {
+		if err := fw.WriteFrame(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fr := NewFrameReader(&buf, len(obj))
+	for i := 0; i < 3; i++ {
+		expectFrame(t, fr, obj)
+	}
+	expectError(t, fr, io.EOF)
+}
@@ -0,0 +1,280 @@
+// Fuzz tests, run one at a time with for example:
+//
+//	go test -fuzz FuzzDecoder
+//
+// Without -fuzz, only the seed corpus is run. Note, like the examples,
+// these tests are not executed with "tinygo test".
+
+package binson
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Adds the Binson objects of the test tables to the seed corpus.
+// Single values are added as the value of field "a".
+func addSeeds(f *testing.F) {
+	add := func(buf []byte) {
+		f.Add(append([]byte(nil), buf...))
+	}
+	for _, record := range validateTable {
+		add(record.raw)
+	}
+	for _, record := range canonicalizeTable {
+		add(record.src)
+	}
+	for _, record := range mergeTable {
+		add(record.base)
+		add(record.patch)
+	}
+	for _, record := range intTable {
+		add(fieldA(record.raw))
+	}
+	for _, record := range doubleTable {
+		add(fieldA(record.raw))
+	}
+	for _, record := range stringTable {
+		add(fieldA(record.raw))
+	}
+	for _, record := range bytesTable {
+		add(fieldA(record.raw))
+	}
+}
+
+// Returns {"a": <raw>}.
+func fieldA(raw []byte) []byte {
+	buf := []byte("\x40\x14\x01\x61")
+	buf = append(buf, raw...)
+	return append(buf, sigEnd)
+}
+
+// Navigation operations of FuzzDecoder, selected by the bytes of ops.
+const (
+	opNextField = iota
+	opNextArrayValue
+	opGoIntoObject
+	opGoIntoArray
+	opGoUpToObject
+	opGoUpToArray
+	opField
+	opAccessors
+	opCount
+)
+
+// Drives a Decoder through a sequence of navigation calls. The calls
+// must not panic, never move the decoder backwards or past the end of
+// the input, and loops over fields and array values must end.
+func FuzzDecoder(f *testing.F) {
+	for _, record := range validateTable {
+		f.Add(record.raw, []byte{opNextField, opGoIntoObject, opNextField, opGoIntoArray,
+			opNextArrayValue, opGoUpToArray, opGoUpToObject, opField, opAccessors})
+	}
+	for _, record := range canonicalizeTable {
+		f.Add(record.src, []byte{opField, opGoIntoArray, opNextArrayValue, opGoIntoObject,
+			opNextField, opGoUpToArray, opNextField})
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte, ops []byte) {
+		d := Decoder{}
+		d.Init(buf)
+		if len(ops) > 0 {
+			d.Strict = ops[0]&0x80 != 0
+		}
+
+		offset := 0
+		for _, op := range ops {
+			switch op % opCount {
+			case opNextField:
+				// At most one field per input byte.
+				for n := 0; d.NextField(); n++ {
+					if n > len(buf) {
+						t.Fatalf("NextField did not end")
+					}
+					if op&0x40 != 0 {
+						break
+					}
+				}
+			case opNextArrayValue:
+				for n := 0; d.NextArrayValue(); n++ {
+					if n > len(buf) {
+						t.Fatalf("NextArrayValue did not end")
+					}
+					if op&0x40 != 0 {
+						break
+					}
+				}
+			case opGoIntoObject:
+				d.GoIntoObject()
+			case opGoIntoArray:
+				d.GoIntoArray()
+			case opGoUpToObject:
+				d.GoUpToObject()
+			case opGoUpToArray:
+				d.GoUpToArray()
+			case opField:
+				d.Field("a")
+			case opAccessors:
+				d.Int8()
+				d.Int16()
+				d.Int32()
+				d.Uint8()
+				d.Uint16()
+				d.Uint32()
+				d.Uint64()
+				d.Float32()
+			}
+
+			if d.Offset() < offset || d.Offset() > len(buf) {
+				t.Fatalf("offset moved from %d to %d, input length %d", offset, d.Offset(), len(buf))
+			}
+			offset = d.Offset()
+		}
+	})
+}
+
+// Checks that decoding and encoding an object gives a stable result:
+// copying a copy changes nothing, and the canonical form of the input,
+// of the copy and of the canonical form itself are the same.
+func FuzzRoundTrip(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		first, ok := copyObject(buf)
+		if !ok {
+			return
+		}
+		second, ok := copyObject(first)
+		if !ok || !bytes.Equal(first, second) {
+			t.Fatalf("copy of 0x%x is not stable: 0x%x, then 0x%x", buf, first, second)
+		}
+
+		canonical := make([]byte, len(buf))
+		n, err := Canonicalize(canonical, buf)
+		if err != ErrorNone {
+			return
+		}
+		canonical = canonical[:n]
+		if err := Validate(canonical); err != ErrorNone {
+			t.Fatalf("canonical form 0x%x is not valid: error %d", canonical, err)
+		}
+
+		again := make([]byte, len(canonical))
+		n, err = Canonicalize(again, canonical)
+		if err != ErrorNone || !bytes.Equal(again[:n], canonical) {
+			t.Fatalf("canonical form 0x%x is not stable: 0x%x, error %d", canonical, again[:n], err)
+		}
+		n, err = Canonicalize(again, first)
+		if err != ErrorNone || !bytes.Equal(again[:n], canonical) {
+			t.Fatalf("canonical form of copy 0x%x is 0x%x, expected 0x%x", first, again[:n], canonical)
+		}
+
+		if Validate(buf) == ErrorNone && !bytes.Equal(first, buf) {
+			t.Fatalf("copy of valid object 0x%x is 0x%x", buf, first)
+		}
+	})
+}
+
+// Checks Validate against the Decoder and Canonicalize: a valid object
+// can be read by a strict Decoder to the end, and is its own
+// canonical form.
+func FuzzValidate(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		if Validate(buf) != ErrorNone {
+			return
+		}
+
+		d := Decoder{Strict: true}
+		d.Init(buf)
+		if n := d.Consumed(); n != len(buf) || d.Error != ErrorNone {
+			t.Fatalf("valid object 0x%x: consumed %d bytes, error %d", buf, n, d.Error)
+		}
+
+		dst := make([]byte, len(buf))
+		n, err := Canonicalize(dst, buf)
+		if err != ErrorNone || !bytes.Equal(dst[:n], buf) {
+			t.Fatalf("valid object 0x%x: canonical form 0x%x, error %d", buf, dst[:n], err)
+		}
+	})
+}
+
+// Decodes the object in buf and encodes its values, in the same order,
+// with the Encoder. Returns false if buf can not be decoded.
+func copyObject(buf []byte) ([]byte, bool) {
+	d := Decoder{}
+	d.Init(buf)
+	e := Encoder{}
+	// Shortest encodings are never longer than the input.
+	e.Init(make([]byte, len(buf)))
+
+	e.Begin()
+	copyFields(&e, &d, 1)
+	e.End()
+	if d.Error != ErrorNone || e.Error != ErrorNone || d.Offset() != len(buf) {
+		return nil, false
+	}
+	return e.buf[:e.Offset], true
+}
+
+// Copies the fields of the object that d is in, up to its end.
+func copyFields(e *Encoder, d *Decoder, depth int) {
+	for d.NextField() {
+		if d.Error != ErrorNone {
+			return
+		}
+		e.NameBytes(d.Name)
+		if copyValue(e, d, depth) {
+			d.GoUpToObject()
+		}
+		if d.Error != ErrorNone {
+			return
+		}
+	}
+}
+
+// Copies the last value read by d. Returns true if d was moved to the
+// end of a nested object or array, and must be moved up to its parent.
+func copyValue(e *Encoder, d *Decoder, depth int) bool {
+	if (d.ValueType == Object || d.ValueType == Array) && depth >= maxDepth {
+		d.Error = ErrorTooDeep
+		return false
+	}
+
+	switch d.ValueType {
+	case Boolean:
+		e.Bool(d.ValueBoolean)
+	case Integer:
+		e.Integer(d.ValueInteger)
+	case Double:
+		e.Double(d.ValueDouble)
+	case String:
+		e.stringBytes(d.ValueBytes)
+	case Bytes:
+		e.Bytes(d.ValueBytes)
+	case Object:
+		d.GoIntoObject()
+		e.Begin()
+		copyFields(e, d, depth+1)
+		e.End()
+		return d.Error == ErrorNone
+	case Array:
+		d.GoIntoArray()
+		e.BeginArray()
+		for d.NextArrayValue() {
+			if d.Error != ErrorNone {
+				return false
+			}
+			if copyValue(e, d, depth+1) {
+				d.GoUpToArray()
+			}
+			if d.Error != ErrorNone {
+				return false
+			}
+		}
+		e.EndArray()
+		return d.Error == ErrorNone
+	}
+	return false
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"math"
 	"testing"
+	"unicode/utf8"
 )
 
 // Binson INTEGER internal representation test data table
@@ -205,3 +206,111 @@ func TestTableBytes(t *testing.T) {
 		}
 	}
 }
+
+// UTF-8 validation test data table, invalid is the index of the first
+// invalid byte or -1
+var utf8Table = []struct {
+	val     string
+	invalid int
+}{
+	{"", -1},
+	{"abc", -1},
+	{"größer", -1},
+	{"爅웡", -1},
+	{"\U0010ffff", -1},
+	{"\xed\x9f\xbf", -1},        // U+D7FF
+	{"a\x80", 1},                // continuation byte
+	{"\xc0\xaf", 0},             // overlong '/'
+	{"\xe0\x80\xaf", 0},         // overlong '/'
+	{"\xed\xa0\x80", 0},         // U+D800, surrogate
+	{"\xf4\x90\x80\x80", 0},     // above U+10FFFF
+	{"ab\xe7\x88", 2},           // truncated
+	{"\xe7\x88\x85\xec\x9b", 3}, // truncated
+	{"\xe7\x88\x85\xec\x9b\xa1", -1},
+	{"\xff", 0},
+}
+
+func TestTableUTF8(t *testing.T) {
+	for _, record := range utf8Table {
+		if i := invalidUTF8(record.val); i != record.invalid {
+			t.Errorf("invalidUTF8 failed: val 0x%v, expected %d != recieved: %d",
+				hex.EncodeToString([]byte(record.val)), record.invalid, i)
+		}
+		if i := invalidUTF8([]byte(record.val)); i != record.invalid {
+			t.Errorf("invalidUTF8 []byte failed: val 0x%v, expected %d != recieved: %d",
+				hex.EncodeToString([]byte(record.val)), record.invalid, i)
+		}
+		if utf8.ValidString(record.val) != (record.invalid == -1) {
+			t.Errorf("test data disagrees with utf8.ValidString: val 0x%v",
+				hex.EncodeToString([]byte(record.val)))
+		}
+	}
+}
+
+// Tests the little-endian conversions at all alignments. Run with
+// "-tags binson_portable" too, to test the portable version.
+func TestUintConversions(t *testing.T) {
+	le := []byte("\x01\x02\x03\x04\x05\x06\x07\x08")
+	buf := make([]byte, 16)
+
+	for offset := 0; offset < 8; offset++ {
+		b := buf[offset:]
+		copy(b, le)
+		assertEqualInt64(t, 0x0201, int64(getUint16(b)))
+		assertEqualInt64(t, 0x04030201, int64(getUint32(b)))
+		if v := getUint64(b); v != 0x0807060504030201 {
+			t.Errorf("getUint64 failed at offset %d: 0x%x", offset, v)
+		}
+
+		for i := range buf {
+			buf[i] = 0xff
+		}
+		putUint16(b, 0x0201)
+		putUint32(b[2:], 0x06050403)
+		if !bytes.Equal(le[:6], b[:6]) || b[6] != 0xff {
+			t.Errorf("putUint16/32 failed at offset %d: 0x%v", offset, hex.EncodeToString(b))
+		}
+		putUint64(b, 0x0807060504030201)
+		if !bytes.Equal(le, b[:8]) || b[8] != 0xff {
+			t.Errorf("putUint64 failed at offset %d: 0x%v", offset, hex.EncodeToString(b))
+		}
+	}
+}
+
+// Compare the versions with, for example:
+//
+//	go test -run XXX -bench Uint -count 10 ./binson > fast.txt
+//	go test -run XXX -bench Uint -count 10 -tags binson_portable ./binson > portable.txt
+//	benchstat portable.txt fast.txt
+//
+// Offsets 0 to 7 are used, most reads are unaligned.
+
+var uintSink uint64
+
+func BenchmarkGetUint16(b *testing.B) {
+	buf := make([]byte, 16)
+	for i := 0; i < b.N; i++ {
+		uintSink += uint64(getUint16(buf[i&7:]))
+	}
+}
+
+func BenchmarkGetUint32(b *testing.B) {
+	buf := make([]byte, 16)
+	for i := 0; i < b.N; i++ {
+		uintSink += uint64(getUint32(buf[i&7:]))
+	}
+}
+
+func BenchmarkGetUint64(b *testing.B) {
+	buf := make([]byte, 16)
+	for i := 0; i < b.N; i++ {
+		uintSink += getUint64(buf[i&7:])
+	}
+}
+
+func BenchmarkPutUint64(b *testing.B) {
+	buf := make([]byte, 16)
+	for i := 0; i < b.N; i++ {
+		putUint64(buf[i&7:], uint64(i))
+	}
+}
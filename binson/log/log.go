@@ -0,0 +1,402 @@
+// Package log stores Binson objects as records in an append-only file.
+//
+// Records are written as frames, see package frame. A sparse index with
+// the offset of every IndexInterval:th record is kept in a second file,
+// named as the log file with ".idx" added. The index file starts with
+// the magic "bidx" and the interval as a 4 byte little-endian integer,
+// followed by entries of 8 byte little-endian offsets. Entry i is the
+// offset of record i*IndexInterval.
+//
+// When a log is opened, the index is checked and completed, and the
+// records after the last index entry are read. An index made with
+// another interval is rebuilt. The entries are checked by reading the
+// headers of the records between them. A torn final record,
+// left by a power loss during Append, is removed by truncating the file.
+// Other invalid records are errors, and the file is not changed.
+// The index file can be deleted at any time, it is rebuilt on open.
+//
+// Open allocates a buffer of the max record size for checking records.
+// The index in memory grows by one entry every IndexInterval appends.
+// Record reads into a buffer given by the caller.
+package log
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+	"github.com/assaabloy-ppi/binson-go-tiny/binson/frame"
+)
+
+// DefaultIndexInterval is the index interval used when 0 is given.
+const DefaultIndexInterval = 64
+
+// The index file header, the magic and the interval.
+const (
+	indexHeaderSize = 8
+	indexMagic      = "bidx"
+)
+
+// Errors returned by Log methods.
+var (
+	ErrNotObject   = errors.New("log: record is not a Binson object")
+	ErrNoRecord    = errors.New("log: no such record")
+	ErrShortBuffer = errors.New("log: buffer too small for record")
+	ErrTooLarge    = errors.New("log: record larger than max record size")
+	ErrCorrupt     = errors.New("log: corrupted record")
+	ErrFailed      = errors.New("log: a failed write could not be undone")
+)
+
+// Options for Open. The zero value gives default values.
+type Options struct {
+	IndexInterval int // records per index entry, 0 means DefaultIndexInterval
+	MaxRecordSize int // max record size, 0 means frame.DefaultMaxSize
+}
+
+// A Log is an append-only file of Binson records.
+// A Log must not be used by several goroutines at the same time.
+type Log struct {
+	file      *os.File
+	indexFile *os.File
+	writer    *frame.FrameWriter
+	interval  int
+	maxSize   int
+	size      int64   // end of the last record
+	count     int     // number of records
+	index     []int64 // offsets of every interval:th record
+	err       error   // set if a failed write could not be undone
+
+	// Truncated is the number of bytes removed from the end of the
+	// file when it was opened, 0 if the last record was complete.
+	Truncated int64
+}
+
+// Open opens the log file at path, creating it if needed.
+func Open(path string, opts *Options) (*Log, error) {
+	l := &Log{interval: DefaultIndexInterval, maxSize: frame.DefaultMaxSize}
+	if opts != nil && opts.IndexInterval > 0 {
+		l.interval = opts.IndexInterval
+	}
+	if opts != nil && opts.MaxRecordSize > 0 {
+		l.maxSize = opts.MaxRecordSize
+	}
+
+	var err error
+	l.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.indexFile, err = os.OpenFile(path+".idx", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		l.file.Close()
+		return nil, err
+	}
+
+	if err := l.recover(); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if _, err := l.file.Seek(l.size, io.SeekStart); err != nil {
+		l.Close()
+		return nil, err
+	}
+	l.writer = frame.NewFrameWriter(l.file, l.maxSize)
+	return l, nil
+}
+
+// Count returns the number of records in the log.
+func (l *Log) Count() int {
+	return l.count
+}
+
+// Size returns the size of the log file in bytes.
+func (l *Log) Size() int64 {
+	return l.size
+}
+
+// Append adds the Binson object obj as the last record. The record
+// is not synced to disk, see Sync.
+// If writing fails, the partly written record is removed. If that also
+// fails, the error is ErrFailed, and all later calls return ErrFailed.
+func (l *Log) Append(obj []byte) error {
+	if l.err != nil {
+		return l.err
+	}
+	if len(obj) > l.maxSize {
+		return ErrTooLarge
+	}
+	if !binson.IsObject(obj) {
+		return ErrNotObject
+	}
+
+	if err := l.writer.WriteFrame(obj); err != nil {
+		return l.undo(err)
+	}
+	if l.count%l.interval == 0 {
+		if err := l.writeIndex(len(l.index), l.size); err != nil {
+			return l.undo(err)
+		}
+		l.index = append(l.index, l.size)
+	}
+	l.size += int64(frame.HeaderSize + len(obj))
+	l.count++
+	return nil
+}
+
+// Truncates the files to the end of the last complete record and its
+// index entry, after err from a write. Returns err, or ErrFailed if
+// the files could not be truncated.
+func (l *Log) undo(err error) error {
+	undoErr := l.file.Truncate(l.size)
+	if undoErr == nil {
+		_, undoErr = l.file.Seek(l.size, io.SeekStart)
+	}
+	if undoErr == nil {
+		undoErr = l.indexFile.Truncate(indexHeaderSize + int64(8*len(l.index)))
+	}
+	if undoErr != nil {
+		l.err = ErrFailed
+		return fmt.Errorf("%w: %v, then %v", ErrFailed, err, undoErr)
+	}
+	return err
+}
+
+// Record reads record n, counting from 0, into buf. Returns the
+// object, a slice of buf.
+func (l *Log) Record(n int, buf []byte) ([]byte, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	if n < 0 || n >= l.count {
+		return nil, ErrNoRecord
+	}
+
+	// Start at the closest index entry, and skip records by
+	// reading only their headers.
+	offset := l.index[n/l.interval]
+	var header [frame.HeaderSize]byte
+	for i := n - n%l.interval; ; i++ {
+		if _, err := l.file.ReadAt(header[:], offset); err != nil {
+			return nil, err
+		}
+		length := int64(binary.LittleEndian.Uint32(header[2:]))
+		if i == n {
+			if int64(len(buf)) < length {
+				return nil, ErrShortBuffer
+			}
+			if _, err := l.file.ReadAt(buf[:length], offset+frame.HeaderSize); err != nil {
+				return nil, err
+			}
+			return buf[:length], nil
+		}
+		offset += frame.HeaderSize + length
+	}
+}
+
+// Sync commits the log and its index to stable storage.
+func (l *Log) Sync() error {
+	if l.err != nil {
+		return l.err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	return l.indexFile.Sync()
+}
+
+// Close closes the log files.
+func (l *Log) Close() error {
+	err := l.file.Close()
+	if err2 := l.indexFile.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// ======== Recovery ========
+
+// Reads the index and the records after it, and truncates the log
+// after the last complete record.
+func (l *Log) recover() error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	if err := l.readIndex(fileSize); err != nil {
+		return err
+	}
+
+	// Read the records after the last index entry.
+	if len(l.index) > 0 {
+		l.size = l.index[len(l.index)-1]
+		l.count = (len(l.index) - 1) * l.interval
+	}
+	buf := make([]byte, l.maxSize)
+	for l.size < fileSize {
+		n, err := l.checkRecord(l.size, fileSize, buf)
+		if err == io.ErrUnexpectedEOF {
+			break // torn last record
+		}
+		if err != nil {
+			return err
+		}
+
+		if l.count%l.interval == 0 && l.count/l.interval == len(l.index) {
+			if err := l.writeIndex(len(l.index), l.size); err != nil {
+				return err
+			}
+			l.index = append(l.index, l.size)
+		}
+		l.size += n
+		l.count++
+	}
+
+	// The last index entry points to the torn record, if it was the
+	// first record of its interval. The count does not include it.
+	if n := len(l.index); n > 0 && l.index[n-1] >= l.size {
+		l.index = l.index[:n-1]
+	}
+
+	if l.size < fileSize {
+		l.Truncated = fileSize - l.size
+		if err := l.file.Truncate(l.size); err != nil {
+			return err
+		}
+	}
+	return l.indexFile.Truncate(indexHeaderSize + int64(8*len(l.index)))
+}
+
+// Checks the record at offset, buf must have room for the largest
+// record. Returns the size of the record with its header, or
+// io.ErrUnexpectedEOF if the file ends within the record.
+func (l *Log) checkRecord(offset, fileSize int64, buf []byte) (int64, error) {
+	var header [frame.HeaderSize]byte
+	n := int64(frame.HeaderSize)
+	if fileSize-offset < n {
+		n = fileSize - offset
+	}
+	if _, err := l.file.ReadAt(header[:n], offset); err != nil {
+		return 0, err
+	}
+	if header[0] != frame.Magic[0] || (n > 1 && header[1] != frame.Magic[1]) {
+		return 0, fmt.Errorf("%w at offset %d", ErrCorrupt, offset)
+	}
+	if n < frame.HeaderSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	length := int64(binary.LittleEndian.Uint32(header[2:]))
+	if length > int64(len(buf)) {
+		return 0, fmt.Errorf("%w at offset %d", ErrTooLarge, offset)
+	}
+	if offset+frame.HeaderSize+length > fileSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if _, err := l.file.ReadAt(buf[:length], offset+frame.HeaderSize); err != nil {
+		return 0, err
+	}
+	if !binson.IsObject(buf[:length]) {
+		return 0, fmt.Errorf("%w at offset %d", ErrCorrupt, offset)
+	}
+	return frame.HeaderSize + length, nil
+}
+
+// Reads the index entries that point to the records with their numbers.
+// The rest of the entries are dropped. An index without a valid header,
+// or with another interval, is emptied and rebuilt by recover.
+func (l *Log) readIndex(fileSize int64) error {
+	info, err := l.indexFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	var header [indexHeaderSize]byte
+	if info.Size() >= indexHeaderSize {
+		if _, err := l.indexFile.ReadAt(header[:], 0); err != nil {
+			return err
+		}
+	}
+	if string(header[:4]) != indexMagic || binary.LittleEndian.Uint32(header[4:]) != uint32(l.interval) {
+		copy(header[:], indexMagic)
+		binary.LittleEndian.PutUint32(header[4:], uint32(l.interval))
+		if err := l.indexFile.Truncate(0); err != nil {
+			return err
+		}
+		_, err := l.indexFile.WriteAt(header[:], 0)
+		return err
+	}
+
+	var entry [8]byte
+	for i := int64(indexHeaderSize); i+8 <= info.Size(); i += 8 {
+		if _, err := l.indexFile.ReadAt(entry[:], i); err != nil {
+			return err
+		}
+		offset := int64(binary.LittleEndian.Uint64(entry[:]))
+
+		// The first entry is record 0, the others must be interval
+		// records after the previous entry.
+		expected := int64(0)
+		if n := len(l.index); n > 0 {
+			if expected, err = l.skipRecords(l.index[n-1], fileSize); err != nil {
+				return err
+			}
+		}
+		if offset != expected || offset+frame.HeaderSize > fileSize {
+			break
+		}
+		ok, err := l.hasMagic(offset)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		l.index = append(l.index, offset)
+	}
+	return nil
+}
+
+// Returns the offset after interval records from offset, by reading
+// their headers, or -1 if there are not as many valid headers.
+func (l *Log) skipRecords(offset, fileSize int64) (int64, error) {
+	var header [frame.HeaderSize]byte
+	for i := 0; i < l.interval; i++ {
+		if offset+frame.HeaderSize > fileSize {
+			return -1, nil
+		}
+		if _, err := l.file.ReadAt(header[:], offset); err != nil {
+			return 0, err
+		}
+		length := int64(binary.LittleEndian.Uint32(header[2:]))
+		if header[0] != frame.Magic[0] || header[1] != frame.Magic[1] || length > int64(l.maxSize) {
+			return -1, nil
+		}
+		offset += frame.HeaderSize + length
+	}
+	if offset > fileSize {
+		return -1, nil
+	}
+	return offset, nil
+}
+
+// Returns true if the frame magic is at offset.
+func (l *Log) hasMagic(offset int64) (bool, error) {
+	var magic [2]byte
+	if _, err := l.file.ReadAt(magic[:], offset); err != nil {
+		return false, err
+	}
+	return magic == frame.Magic, nil
+}
+
+func (l *Log) writeIndex(i int, offset int64) error {
+	var entry [8]byte
+	binary.LittleEndian.PutUint64(entry[:], uint64(offset))
+	_, err := l.indexFile.WriteAt(entry[:], indexHeaderSize+int64(8*i))
+	return err
+}
@@ -0,0 +1,367 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+	"github.com/assaabloy-ppi/binson-go-tiny/binson/frame"
+)
+
+// Returns the record {"n":n,"s":"..."} with a size that depends on n.
+func newRecord(n int) []byte {
+	buf := make([]byte, 100)
+	e := binson.Encoder{}
+	e.Init(buf)
+	e.Begin()
+	e.FieldInt("n", int64(n))
+	e.FieldString("s", "abcdefghijklmnopqrstuvwxyz"[:n%27])
+	e.End()
+	return buf[:e.Offset]
+}
+
+func openLog(t *testing.T, path string) *Log {
+	t.Helper()
+	l, err := Open(path, &Options{IndexInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func appendRecords(t *testing.T, l *Log, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := l.Append(newRecord(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkRecords(t *testing.T, l *Log, count int) {
+	t.Helper()
+	if l.Count() != count {
+		t.Fatalf("expected %d records, got %d", count, l.Count())
+	}
+	buf := make([]byte, 100)
+	for i := count - 1; i >= 0; i-- {
+		obj, err := l.Record(i, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(newRecord(i), obj) {
+			t.Errorf("record %d: unexpected object %x", i, obj)
+		}
+	}
+}
+
+func TestAppendAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	l := openLog(t, path)
+	appendRecords(t, l, 0, 10)
+	checkRecords(t, l, 10)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, path)
+	checkRecords(t, l, 10)
+	appendRecords(t, l, 10, 13)
+	checkRecords(t, l, 13)
+	if l.Truncated != 0 {
+		t.Errorf("expected nothing truncated, got %d", l.Truncated)
+	}
+	l.Close()
+
+	info, _ := os.Stat(path + ".idx")
+	if info.Size() != indexHeaderSize+8*4 {
+		t.Errorf("expected 4 index entries, got index file size %d", info.Size())
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	l := openLog(t, filepath.Join(t.TempDir(), "events.log"))
+	defer l.Close()
+	appendRecords(t, l, 0, 3)
+
+	if _, err := l.Record(3, make([]byte, 100)); err != ErrNoRecord {
+		t.Errorf("expected ErrNoRecord, got %v", err)
+	}
+	if _, err := l.Record(-1, make([]byte, 100)); err != ErrNoRecord {
+		t.Errorf("expected ErrNoRecord, got %v", err)
+	}
+	if _, err := l.Record(2, make([]byte, 5)); err != ErrShortBuffer {
+		t.Errorf("expected ErrShortBuffer, got %v", err)
+	}
+	if err := l.Append([]byte("\x40\x41\x41")); err != ErrNotObject {
+		t.Errorf("expected ErrNotObject, got %v", err)
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path)
+	appendRecords(t, l, 0, 9)
+	size := l.Size()
+	l.Close()
+
+	// Simulate a power loss while appending record 9.
+	rec := newRecord(9)
+	for cut := 1; cut < 6+len(rec); cut++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("\xb1\x5e")[:min(cut, 2)])
+		if cut > 2 {
+			f.Write([]byte{byte(len(rec)), 0, 0, 0}[:min(cut-2, 4)])
+		}
+		if cut > 6 {
+			f.Write(rec[:cut-6])
+		}
+		f.Close()
+
+		l = openLog(t, path)
+		if l.Truncated != int64(cut) {
+			t.Errorf("cut %d: expected %d bytes truncated, got %d", cut, cut, l.Truncated)
+		}
+		if l.Size() != size {
+			t.Errorf("cut %d: expected size %d, got %d", cut, size, l.Size())
+		}
+		checkRecords(t, l, 9)
+		l.Close()
+	}
+
+	l = openLog(t, path)
+	appendRecords(t, l, 9, 10)
+	checkRecords(t, l, 10)
+	l.Close()
+}
+
+func TestTornRecordWithIndexEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path)
+	appendRecords(t, l, 0, 9)
+	offset := l.index[2] // record 8, the first with the last index entry
+	l.Close()
+
+	// The header of record 8 is intact, but not all of the object.
+	os.Truncate(path, offset+frame.HeaderSize+2)
+	l = openLog(t, path)
+	if l.Truncated != frame.HeaderSize+2 {
+		t.Errorf("expected %d bytes truncated, got %d", frame.HeaderSize+2, l.Truncated)
+	}
+	if len(l.index) != 2 {
+		t.Errorf("expected 2 index entries, got %d", len(l.index))
+	}
+	appendRecords(t, l, 8, 13)
+	checkRecords(t, l, 13)
+	l.Close()
+
+	l = openLog(t, path)
+	checkRecords(t, l, 13)
+	if len(l.index) != 4 {
+		t.Errorf("expected 4 index entries, got %d", len(l.index))
+	}
+	l.Close()
+}
+
+func TestCorruptedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path)
+	appendRecords(t, l, 0, 10)
+	offset := l.index[2] // record 8, after the last index entry
+	size := l.Size()
+	l.Close()
+
+	// A corrupted record before the last one is not removed.
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{0}, offset+frame.HeaderSize)
+	f.Close()
+	if _, err := Open(path, &Options{IndexInterval: 4}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Errorf("expected file size %d, got %d", size, info.Size())
+	}
+
+	// Nor are records larger than the max size.
+	f, _ = os.OpenFile(path, os.O_WRONLY, 0)
+	f.WriteAt([]byte{0x40}, offset+frame.HeaderSize)
+	f.Close()
+	if _, err := Open(path, &Options{IndexInterval: 4, MaxRecordSize: 10}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Errorf("expected file size %d, got %d", size, info.Size())
+	}
+
+	l = openLog(t, path)
+	checkRecords(t, l, 10)
+	l.Close()
+}
+
+// Writes at most n bytes, then fails.
+type failingWriter struct {
+	w io.Writer
+	n int
+}
+
+var errWrite = errors.New("write failed")
+
+func (fw *failingWriter) Write(b []byte) (int, error) {
+	if len(b) <= fw.n {
+		fw.n -= len(b)
+		return fw.w.Write(b)
+	}
+	n, _ := fw.w.Write(b[:fw.n])
+	fw.n = 0
+	return n, errWrite
+}
+
+func TestFailedAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path)
+	appendRecords(t, l, 0, 7)
+	size := l.Size()
+
+	// Fail within the header and within the object of record 7.
+	for _, n := range []int{3, frame.HeaderSize + 5} {
+		l.writer = frame.NewFrameWriter(&failingWriter{w: l.file, n: n}, 0)
+		if err := l.Append(newRecord(7)); err != errWrite {
+			t.Errorf("expected errWrite, got %v", err)
+		}
+		if info, _ := os.Stat(path); info.Size() != size {
+			t.Errorf("expected file size %d, got %d", size, info.Size())
+		}
+	}
+	l.writer = frame.NewFrameWriter(l.file, 0)
+	appendRecords(t, l, 7, 10)
+	checkRecords(t, l, 10)
+	l.Close()
+
+	l = openLog(t, path)
+	checkRecords(t, l, 10)
+	if l.Truncated != 0 || len(l.index) != 3 {
+		t.Errorf("expected nothing truncated and 3 index entries, got %d and %d", l.Truncated, len(l.index))
+	}
+	l.Close()
+}
+
+func TestFailedIndexWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path)
+	appendRecords(t, l, 0, 8)
+
+	// The index entry of record 8 can not be written, and the index
+	// file can not be truncated, so the log is failed.
+	l.indexFile.Close()
+	if err := l.Append(newRecord(8)); !errors.Is(err, ErrFailed) {
+		t.Errorf("expected ErrFailed, got %v", err)
+	}
+	if err := l.Append(newRecord(8)); err != ErrFailed {
+		t.Errorf("expected ErrFailed, got %v", err)
+	}
+	if _, err := l.Record(0, make([]byte, 100)); err != ErrFailed {
+		t.Errorf("expected ErrFailed, got %v", err)
+	}
+	if err := l.Sync(); err != ErrFailed {
+		t.Errorf("expected ErrFailed, got %v", err)
+	}
+	l.Close()
+
+	// The record was removed from the log file.
+	l = openLog(t, path)
+	checkRecords(t, l, 8)
+	appendRecords(t, l, 8, 10)
+	checkRecords(t, l, 10)
+	l.Close()
+}
+
+func TestOtherInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l, err := Open(path, &Options{IndexInterval: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, l, 0, 10)
+	l.Close()
+
+	// The index is rebuilt with the default interval, and again
+	// with interval 2.
+	for _, opts := range []*Options{nil, {IndexInterval: 2}} {
+		l, err = Open(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRecords(t, l, 10)
+		if n := (10 + l.interval - 1) / l.interval; len(l.index) != n {
+			t.Errorf("interval %d: expected %d index entries, got %d", l.interval, n, len(l.index))
+		}
+		l.Close()
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path)
+	appendRecords(t, l, 0, 11)
+	l.Close()
+
+	os.Remove(path + ".idx")
+	l = openLog(t, path)
+	checkRecords(t, l, 11)
+	l.Close()
+
+	// Entries that do not point to records are dropped.
+	os.WriteFile(path+".idx", []byte("bidx\x04\x00\x00\x00"+
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00\x00\x00\x00\x00"), 0644)
+	l = openLog(t, path)
+	checkRecords(t, l, 11)
+	l.Close()
+
+	// So are entries that point to records with other numbers.
+	l = openLog(t, path)
+	index := l.index
+	l.Close()
+	entries := make([]byte, indexHeaderSize+8*3)
+	copy(entries, "bidx\x04\x00\x00\x00")
+	binary.LittleEndian.PutUint64(entries[8:], uint64(index[0]))
+	binary.LittleEndian.PutUint64(entries[16:], uint64(index[2])) // record 8, not 4
+	binary.LittleEndian.PutUint64(entries[24:], uint64(index[1]))
+	os.WriteFile(path+".idx", entries, 0644)
+	l = openLog(t, path)
+	checkRecords(t, l, 11)
+	if len(l.index) != 3 || l.index[1] != index[1] {
+		t.Errorf("expected the index %v, got %v", index, l.index)
+	}
+	l.Close()
+
+	// Entries after the end of a truncated log are dropped.
+	l = openLog(t, path)
+	offset := l.index[2]
+	l.Close()
+	os.Truncate(path, offset+3)
+	l = openLog(t, path)
+	checkRecords(t, l, 8)
+	if len(l.index) != 2 {
+		t.Errorf("expected 2 index entries, got %d", len(l.index))
+	}
+	l.Close()
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,419 @@
+// Package rpc implements a small request/response protocol with Binson
+// messages, sent as frames (see package frame) over any io.ReadWriter.
+//
+// A request is an object with the fields:
+//
+//	{"id": integer, "method": string, "params": object}
+//
+// A response has the id of its request, and either a result or an error:
+//
+//	{"id": integer, "result": object}
+//	{"error": string, "id": integer}
+//
+// Server.Serve allocates one response buffer of the max message size.
+// A Client allocates a request buffer of the max message size, and for
+// each call a channel and a copy of the result object.
+package rpc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+	"github.com/assaabloy-ppi/binson-go-tiny/binson/frame"
+)
+
+// Field names of requests and responses.
+const (
+	FieldError  = "error"
+	FieldID     = "id"
+	FieldMethod = "method"
+	FieldParams = "params"
+	FieldResult = "result"
+)
+
+// DefaultTimeout is the Client.Timeout of a new client.
+const DefaultTimeout = 5 * time.Second
+
+// Errors returned by Client and Server.
+var (
+	ErrTimeout       = errors.New("rpc: timeout")
+	ErrClosed        = errors.New("rpc: client closed")
+	ErrMessageSize   = errors.New("rpc: message too large")
+	ErrUnknownMethod = errors.New("rpc: unknown method")
+	ErrBadMessage    = errors.New("rpc: malformed message")
+)
+
+// RemoteError is returned by Client.Call when the server responds
+// with an error.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "rpc: remote error: " + e.Message
+}
+
+// ======== Server ========
+
+// A Handler handles the requests for one method. params is positioned
+// inside the params object of the request, read its fields with
+// params.Field() or params.NextField(). The object begin of the result
+// is already written to result, the handler writes the fields of the
+// result object. If a non-nil error is returned, the result is
+// discarded and the error message is sent to the client.
+type Handler func(params *binson.Decoder, result *binson.Encoder) error
+
+// A Server dispatches requests to registered handlers.
+type Server struct {
+	handlers map[string]Handler
+
+	// MaxMessageSize is the max size of requests and responses,
+	// 0 means frame.DefaultMaxSize.
+	MaxMessageSize int
+}
+
+// NewServer returns a server with no handlers.
+func NewServer() *Server {
+	return &Server{handlers: make(map[string]Handler)}
+}
+
+// Register sets the handler for method.
+func (s *Server) Register(method string, h Handler) {
+	s.handlers[method] = h
+}
+
+// Serve reads requests from rw and writes responses to rw, until
+// reading fails. Requests are handled one at a time, in order.
+// Returns nil when rw reaches end of input, otherwise the error.
+// Corrupted frames and malformed requests are skipped.
+func (s *Server) Serve(rw io.ReadWriter) error {
+	r := frame.NewFrameReader(rw, s.MaxMessageSize)
+	w := frame.NewFrameWriter(rw, s.MaxMessageSize)
+	size := s.MaxMessageSize
+	if size <= 0 {
+		size = frame.DefaultMaxSize
+	}
+	buf := make([]byte, size)
+
+	for {
+		req, err := r.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err == frame.ErrCorrupt {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		n, ok := s.handle(req, buf)
+		if !ok {
+			continue
+		}
+		if err := w.WriteFrame(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// Handles one request and writes the response to buf. Returns the
+// size of the response, and false if there is no valid request id
+// to respond to.
+func (s *Server) handle(req []byte, buf []byte) (int, bool) {
+	var d binson.Decoder
+	d.Init(req)
+	var id int64
+	var method []byte
+	haveID, haveParams := false, false
+
+	// The fields are sorted, params is the last one.
+	for !haveParams && d.NextField() {
+		switch string(d.Name) {
+		case FieldID:
+			id, haveID = d.ValueInteger, d.ValueType == binson.Integer
+		case FieldMethod:
+			if d.ValueType == binson.String {
+				method = d.ValueBytes
+			}
+		case FieldParams:
+			if d.ValueType == binson.Object {
+				d.GoIntoObject()
+				haveParams = true
+			}
+		}
+	}
+	if !haveID || d.Error != binson.ErrorNone {
+		return 0, false
+	}
+
+	h, ok := s.handlers[string(method)]
+	var err error
+	switch {
+	case !ok:
+		err = ErrUnknownMethod
+	case !haveParams:
+		err = ErrBadMessage
+	}
+
+	var e binson.Encoder
+	if err == nil {
+		e.Init(buf)
+		e.Begin()
+		e.FieldInt(FieldID, id)
+		e.FieldBeginObject(FieldResult)
+		err = h(&d, &e)
+		e.End()
+		e.End()
+		if err == nil && e.Error != binson.ErrorNone {
+			err = ErrMessageSize
+		}
+	}
+
+	if err != nil {
+		e.Init(buf)
+		e.Begin()
+		e.FieldString(FieldError, errorMessage(err, len(buf)-errorOverhead))
+		e.FieldInt(FieldID, id)
+		e.End()
+	}
+	return e.Offset, e.Error == binson.ErrorNone
+}
+
+// Size of an error response without the message: the object begin and
+// end, the field names, and the largest length and id encodings.
+const errorOverhead = 1 + 7 + 5 + 4 + 9 + 1
+
+// Returns the message of err as valid UTF-8, truncated at a character
+// boundary to at most max bytes, so that the error response fits.
+func errorMessage(err error, max int) string {
+	msg := strings.ToValidUTF8(err.Error(), "\uFFFD")
+	if max < 0 {
+		max = 0
+	}
+	if len(msg) > max {
+		for max > 0 && !utf8.RuneStart(msg[max]) {
+			max--
+		}
+		msg = msg[:max]
+	}
+	return msg
+}
+
+// ======== Client ========
+
+type response struct {
+	result []byte
+	err    error
+}
+
+// A Client sends requests to a server and waits for the responses.
+// Several goroutines may call Call at the same time, responses are
+// matched with requests by their id.
+type Client struct {
+	rw io.ReadWriteCloser
+
+	writeMutex sync.Mutex // protects w and buf
+	w          *frame.FrameWriter
+	buf        []byte
+
+	mutex   sync.Mutex // protects the fields below
+	nextID  int64
+	pending map[int64]chan response
+	err     error // set when the client can no longer be used
+
+	// Timeout is the max time Call waits for writing the request and
+	// for the response. Writing can only be stopped if rw has a
+	// SetWriteDeadline method, like net.Conn, otherwise a peer that
+	// does not read can block Call. A request that times out while
+	// being written is left partly written, the peer skips it.
+	Timeout time.Duration
+}
+
+// Implemented by net.Conn and os.File.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// NewClient returns a client that sends requests on rw. Messages larger
+// than maxMessageSize bytes are rejected, 0 means frame.DefaultMaxSize.
+// A goroutine reads responses from rw until reading fails, Close closes
+// rw to stop it.
+func NewClient(rw io.ReadWriteCloser, maxMessageSize int) *Client {
+	if maxMessageSize <= 0 {
+		maxMessageSize = frame.DefaultMaxSize
+	}
+	c := &Client{
+		rw:      rw,
+		w:       frame.NewFrameWriter(rw, maxMessageSize),
+		buf:     make([]byte, maxMessageSize),
+		pending: make(map[int64]chan response),
+		Timeout: DefaultTimeout,
+	}
+	go c.readResponses(frame.NewFrameReader(rw, maxMessageSize))
+	return c
+}
+
+// Call sends a request for method and waits for the response.
+// params writes the fields of the params object, it may be nil.
+// Returns the result object of the response. If the server responded
+// with an error, a *RemoteError is returned.
+func (c *Client) Call(method string, params func(e *binson.Encoder)) ([]byte, error) {
+	ch := make(chan response, 1)
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mutex.Unlock()
+
+	deadline := time.Now().Add(c.Timeout)
+	if err := c.send(id, method, params, deadline); err != nil {
+		c.forget(id)
+		return nil, err
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		return resp.result, resp.err
+	case <-timer.C:
+		c.forget(id)
+		return nil, ErrTimeout
+	}
+}
+
+// Close closes rw, which stops the goroutine that reads responses, and
+// makes all waiting and future calls return ErrClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return c.rw.Close()
+}
+
+func (c *Client) send(id int64, method string, params func(e *binson.Encoder), deadline time.Time) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	var e binson.Encoder
+	e.Init(c.buf)
+	e.Begin()
+	e.FieldInt(FieldID, id)
+	e.FieldString(FieldMethod, method)
+	e.FieldBeginObject(FieldParams)
+	if params != nil {
+		params(&e)
+	}
+	e.End()
+	e.End()
+	if e.Error != binson.ErrorNone {
+		return ErrMessageSize
+	}
+
+	if conn, ok := c.rw.(writeDeadliner); ok {
+		if err := conn.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	err := c.w.WriteFrame(c.buf[:e.Offset])
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return ErrTimeout
+	}
+	return err
+}
+
+func (c *Client) forget(id int64) {
+	c.mutex.Lock()
+	delete(c.pending, id)
+	c.mutex.Unlock()
+}
+
+// Fails all waiting calls and future calls with err.
+func (c *Client) fail(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		ch <- response{err: c.err}
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) readResponses(r *frame.FrameReader) {
+	for {
+		msg, err := r.ReadFrame()
+		if err == frame.ErrCorrupt {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			c.fail(err)
+			return
+		}
+
+		id, resp, ok := parseResponse(msg)
+		if !ok {
+			continue
+		}
+		c.mutex.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		if ch != nil {
+			ch <- resp // buffered, never blocks
+		}
+	}
+}
+
+// Parses a response message. Returns false if it has no id.
+// The result is copied. An error that is not a string, or a missing
+// result, gives ErrBadMessage.
+func parseResponse(msg []byte) (int64, response, bool) {
+	var d binson.Decoder
+	d.Init(msg)
+	var id int64
+	var resp response
+	haveID, haveResult := false, false
+
+	for d.NextField() {
+		switch string(d.Name) {
+		case FieldError:
+			if d.ValueType == binson.String {
+				resp.err = &RemoteError{Message: string(d.ValueBytes)}
+			} else {
+				resp.err = ErrBadMessage
+			}
+		case FieldID:
+			id, haveID = d.ValueInteger, d.ValueType == binson.Integer
+		case FieldResult:
+			if d.ValueType == binson.Object {
+				start := d.Offset() - 1
+				d.GoIntoObject()
+				d.GoUpToObject()
+				resp.result = append([]byte(nil), msg[start:d.Offset()]...)
+				haveResult = true
+			}
+		}
+	}
+	if !haveID || d.Error != binson.ErrorNone {
+		return 0, resp, false
+	}
+	if resp.err == nil && !haveResult {
+		resp.err = ErrBadMessage
+	}
+	return id, resp, true
+}
@@ -0,0 +1,236 @@
+package rpc
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// Starts a server with the methods "add" and "sleep" on one end of
+// a pipe, and returns a client on the other end.
+func newPipe(t *testing.T) *Client {
+	server := NewServer()
+	server.Register("add", func(params *binson.Decoder, result *binson.Encoder) error {
+		var sum int64
+		for params.NextField() {
+			if params.ValueType != binson.Integer {
+				return errors.New("integer expected")
+			}
+			sum += params.ValueInteger
+		}
+		result.FieldInt("sum", sum)
+		return nil
+	})
+	server.Register("sleep", func(params *binson.Decoder, result *binson.Encoder) error {
+		params.Field("ms")
+		time.Sleep(time.Duration(params.ValueInteger) * time.Millisecond)
+		return nil
+	})
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(serverConn)
+	}()
+
+	c := NewClient(clientConn, 0)
+	t.Cleanup(func() {
+		c.Close()
+		serverConn.Close()
+		<-done
+	})
+	return c
+}
+
+func add(c *Client, a, b int64) (int64, error) {
+	result, err := c.Call("add", func(e *binson.Encoder) {
+		e.FieldInt("a", a)
+		e.FieldInt("b", b)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var d binson.Decoder
+	d.Init(result)
+	if !d.Field("sum") {
+		return 0, errors.New("no sum in result")
+	}
+	return d.ValueInteger, nil
+}
+
+func TestCall(t *testing.T) {
+	c := newPipe(t)
+
+	sum, err := add(c, 2, 3)
+	if err != nil || sum != 5 {
+		t.Errorf("expected 5, got %d, %v", sum, err)
+	}
+
+	result, err := c.Call("sleep", nil)
+	if err != nil || string(result) != "\x40\x41" {
+		t.Errorf("expected empty result, got %x, %v", result, err)
+	}
+}
+
+func TestRemoteErrors(t *testing.T) {
+	c := newPipe(t)
+
+	_, err := c.Call("nosuchmethod", nil)
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Message != ErrUnknownMethod.Error() {
+		t.Errorf("expected unknown method error, got %v", err)
+	}
+
+	_, err = c.Call("add", func(e *binson.Encoder) {
+		e.FieldString("a", "x")
+	})
+	if !errors.As(err, &remote) || remote.Message != "integer expected" {
+		t.Errorf("expected handler error, got %v", err)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	c := newPipe(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			sum, err := add(c, i, 1000)
+			if err != nil || sum != i+1000 {
+				t.Errorf("expected %d, got %d, %v", i+1000, sum, err)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+}
+
+func TestTimeout(t *testing.T) {
+	c := newPipe(t)
+	c.Timeout = 20 * time.Millisecond
+
+	_, err := c.Call("sleep", func(e *binson.Encoder) {
+		e.FieldInt("ms", 100)
+	})
+	if err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+
+	// The late response is dropped, the next call gets its own.
+	c.Timeout = DefaultTimeout
+	sum, err := add(c, 1, 1)
+	if err != nil || sum != 2 {
+		t.Errorf("expected 2, got %d, %v", sum, err)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	// Nothing reads the server end, so writing the request blocks.
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	c := NewClient(clientConn, 0)
+	defer c.Close()
+	c.Timeout = 20 * time.Millisecond
+
+	if _, err := c.Call("add", nil); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if _, err := c.Call("add", nil); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	var remoteErr *RemoteError
+
+	// {"error":"x","id":1}
+	id, resp, ok := parseResponse([]byte("\x40\x14\x05error\x14\x01x\x14\x02id\x10\x01\x41"))
+	if !ok || id != 1 || !errors.As(resp.err, &remoteErr) || remoteErr.Message != "x" {
+		t.Errorf("unexpected response %d, %v, %v", id, resp.err, ok)
+	}
+
+	// {"error":1,"id":1}, {"error":0x,"id":1}
+	for _, msg := range []string{
+		"\x40\x14\x05error\x10\x01\x14\x02id\x10\x01\x41",
+		"\x40\x14\x05error\x18\x00\x14\x02id\x10\x01\x41",
+	} {
+		id, resp, ok = parseResponse([]byte(msg))
+		if !ok || id != 1 || resp.err != ErrBadMessage {
+			t.Errorf("expected ErrBadMessage, got %d, %v, %v", id, resp.err, ok)
+		}
+	}
+
+	// {"id":1}
+	if _, resp, _ := parseResponse([]byte("\x40\x14\x02id\x10\x01\x41")); resp.err != ErrBadMessage {
+		t.Errorf("expected ErrBadMessage, got %v", resp.err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c := newPipe(t)
+	c.Close()
+
+	if _, err := c.Call("add", nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestMessageSize(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	c := NewClient(clientConn, 20)
+	defer c.Close()
+
+	_, err := c.Call("add", func(e *binson.Encoder) {
+		e.FieldString("a", "too long for the buffer")
+	})
+	if err != ErrMessageSize {
+		t.Errorf("expected ErrMessageSize, got %v", err)
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	long := strings.Repeat("é", 100)
+	server := NewServer()
+	server.MaxMessageSize = 64
+	server.Register("long", func(params *binson.Decoder, result *binson.Encoder) error {
+		return errors.New(long)
+	})
+	server.Register("invalid", func(params *binson.Decoder, result *binson.Encoder) error {
+		return errors.New("bad \xff")
+	})
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(serverConn)
+	}()
+	c := NewClient(clientConn, 64)
+	c.Timeout = time.Second
+	defer func() {
+		c.Close()
+		serverConn.Close()
+		<-done
+	}()
+
+	// The message is truncated to fit the response, at a character boundary.
+	var remote *RemoteError
+	_, err := c.Call("long", nil)
+	if !errors.As(err, &remote) || remote.Message == "" || !strings.HasPrefix(long, remote.Message) ||
+		!utf8.ValidString(remote.Message) {
+		t.Errorf("expected truncated message, got %v", err)
+	}
+
+	_, err = c.Call("invalid", nil)
+	if !errors.As(err, &remote) || remote.Message != "bad \uFFFD" {
+		t.Errorf("expected replaced bytes, got %v", err)
+	}
+}
@@ -0,0 +1,605 @@
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// ======== Code generation ========
+
+// Generate returns Go source code, in package pkg, with a typed writer
+// and reader for messages with the object schema s. The generated code
+// uses binson.Encoder and binson.Decoder only, with no reflection and
+// no memory allocation, so it can be used with TinyGo.
+//
+// For name "Msg", the types MsgWriter and MsgReader are generated.
+// Nested objects get types named after their fields, like MsgPosWriter
+// and MsgPosReader for the field pos. Field names are converted to
+// Go names in camel case, "temp_c" becomes TempC. Names that clash with
+// the generated methods and fields get "_" added, like Go keywords: the
+// field decode gets the accessor Decode_ and the struct field decode_.
+// A schema with fields that give the same type names, like the field b
+// in a and the field a_b, which both give MsgAB, is an error.
+//
+// NewMsgWriter takes the values of the required fields, so a message
+// without them cannot be written. MsgWriter has a SetX method for each
+// field. MsgWriter.Encode writes the fields in sorted order. It sets
+// e.Error to binson.ErrorOutOfRange, and writes nothing, if a value is
+// out of its range or length bounds.
+//
+// MsgReader.Decode reads and checks a message. Each field has an
+// accessor; those of optional fields also return false if the field
+// is missing. Strings and bytes are returned as slices of the decoded
+// buffer. Arrays are returned as iterators. Fields that are not in the
+// schema are ignored.
+//
+// Values of type any and arrays of arrays are not supported.
+func Generate(s *Value, pkg, name string) ([]byte, error) {
+	if s.Any || s.Type != binson.Object {
+		return nil, errors.New("schema: generate: schema is not an object schema")
+	}
+	if !token.IsIdentifier(name) {
+		return nil, fmt.Errorf("schema: generate: invalid type name %q", name)
+	}
+
+	g := generator{names: make(map[string]string)}
+	g.printf("// Code generated by binsongen. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", pkg)
+	g.printf("import \"github.com/assaabloy-ppi/binson-go-tiny/binson\"\n")
+	if err := g.object(s, name, name); err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("schema: generate: %v", err)
+	}
+	return src, nil
+}
+
+type generator struct {
+	buf   bytes.Buffer
+	names map[string]string // generated types and functions, and their schemas
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// A field of an object schema, with the names used in generated code.
+type genField struct {
+	*Field
+	goName  string // exported name, like TempC
+	varName string // unexported name, like tempC
+	sub     string // type name prefix of nested objects, like MsgTempC
+}
+
+// Generates the writer and reader for the object schema s, and the types
+// of its nested objects. what describes s in errors.
+func (g *generator) object(s *Value, name, what string) error {
+	fields, err := genFields(s, name)
+	if err != nil {
+		return err
+	}
+
+	err = g.declare(what, name+"Writer", "New"+name+"Writer", name+"Reader")
+	for _, f := range fields {
+		if err == nil && f.Value.Type == binson.Array {
+			err = g.declare(fieldWhat(&f, name), f.sub+"Iterator", "check"+f.sub)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	g.writer(name, fields)
+	g.reader(name, fields)
+
+	for _, f := range fields {
+		switch {
+		case f.Value.Type == binson.Object:
+			err = g.object(&f.Value, f.sub, fieldWhat(&f, name))
+		case f.Value.Type == binson.Array && f.Value.Elem.Type == binson.Object:
+			err = g.object(f.Value.Elem, f.sub, fieldWhat(&f, name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records the top-level names generated for what. Returns an error if
+// a name is already used, which happens when field names run together.
+func (g *generator) declare(what string, names ...string) error {
+	for _, name := range names {
+		if prev, ok := g.names[name]; ok {
+			return fmt.Errorf("schema: generate: %s and %s have the same Go name %s", prev, what, name)
+		}
+		g.names[name] = what
+	}
+	return nil
+}
+
+func fieldWhat(f *genField, name string) string {
+	return fmt.Sprintf("field %s of %s", f.Name, name)
+}
+
+// Methods of the generated writers and readers with unexported names,
+// which struct fields must not have.
+var methodNames = map[string]bool{"encode": true, "decode": true, "valid": true}
+
+// Returns the fields of s, sorted by name, and checks that they
+// are supported.
+func genFields(s *Value, name string) ([]genField, error) {
+	var fields []genField
+	goNames := make(map[string]bool)
+	for i := range s.Fields {
+		f := genField{Field: &s.Fields[i], goName: goName(s.Fields[i].Name)}
+		if f.goName == "Decode" {
+			f.goName += "_" // the accessor would clash with Decode
+		}
+		f.sub = name + f.goName
+
+		if goNames[f.goName] {
+			return nil, fmt.Errorf("schema: generate: fields of %s have the same Go name %s", name, f.goName)
+		}
+		goNames[f.goName] = true
+
+		v := &f.Value
+		if v.Any {
+			return nil, fmt.Errorf("schema: generate: field %s of %s: any is not supported", f.Name, name)
+		}
+		if v.Type == binson.Array && (v.Elem == nil || v.Elem.Type == binson.Array) {
+			return nil, fmt.Errorf("schema: generate: field %s of %s: only arrays of objects and single values are supported", f.Name, name)
+		}
+		fields = append(fields, f)
+	}
+
+	// Struct fields must not clash with methods, the presence flags
+	// hasX of other fields or, in constructors, the writer type.
+	for i := range fields {
+		f := &fields[i]
+		f.varName = strings.ToLower(f.goName[:1]) + f.goName[1:]
+		if token.IsKeyword(f.varName) || methodNames[f.varName] || f.varName == name+"Writer" ||
+			(strings.HasPrefix(f.varName, "has") && goNames[f.varName[3:]]) {
+			f.varName += "_"
+		}
+	}
+
+	// Sorted as Binson fields; Go compares strings bytewise.
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields, nil
+}
+
+// Converts a field name to an exported Go name.
+func goName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if s == "" || !unicode.IsLetter([]rune(s)[0]) {
+		s = "F" + s
+	}
+	return s
+}
+
+// Go types of values in writers and readers.
+func writerType(v *Value, sub string) string {
+	switch v.Type {
+	case binson.Boolean:
+		return "bool"
+	case binson.Integer:
+		return "int64"
+	case binson.Double:
+		return "float64"
+	case binson.String:
+		return "string"
+	case binson.Bytes:
+		return "[]byte"
+	case binson.Object:
+		return sub + "Writer"
+	}
+	return "[]" + writerType(v.Elem, sub)
+}
+
+func readerType(v *Value, sub string) string {
+	switch v.Type {
+	case binson.Boolean:
+		return "bool"
+	case binson.Integer:
+		return "int64"
+	case binson.Double:
+		return "float64"
+	case binson.String, binson.Bytes:
+		return "[]byte"
+	case binson.Object:
+		return "*" + sub + "Reader"
+	}
+	return sub + "Iterator"
+}
+
+// ======== Writer ========
+
+func (g *generator) writer(name string, fields []genField) {
+	w := name + "Writer"
+	g.printf("\n// %s writes %s objects.\n", w, name)
+	g.printf("type %s struct {\n", w)
+	for _, f := range fields {
+		g.printf("%s %s\n", f.varName, writerType(&f.Value, f.sub))
+		if f.Optional {
+			g.printf("has%s bool\n", f.goName)
+		}
+	}
+	g.printf("}\n")
+
+	// Constructor with the required fields.
+	var params, assigns []string
+	for _, f := range fields {
+		if !f.Optional {
+			params = append(params, f.varName+" "+writerType(&f.Value, f.sub))
+			assigns = append(assigns, f.varName+": "+f.varName+",")
+		}
+	}
+	g.printf("\n// New%s returns a writer with the required fields set.\n", w)
+	g.printf("func New%s(%s) %s {\n", w, strings.Join(params, ", "), w)
+	g.printf("return %s{\n%s\n}\n}\n", w, strings.Join(assigns, "\n"))
+
+	for _, f := range fields {
+		g.printf("\n// Set%s sets the %s field.\n", f.goName, strconv.Quote(f.Name))
+		g.printf("func (w *%s) Set%s(v %s) {\n", w, f.goName, writerType(&f.Value, f.sub))
+		g.printf("w.%s = v\n", f.varName)
+		if f.Optional {
+			g.printf("w.has%s = true\n", f.goName)
+		}
+		g.printf("}\n")
+	}
+
+	g.printf("\n// Encode writes the object to e. If a value is out of range,\n")
+	g.printf("// nothing is written and e.Error is set to binson.ErrorOutOfRange.\n")
+	g.printf("func (w *%s) Encode(e *binson.Encoder) {\n", w)
+	g.printf("if !w.valid() {\ne.Error = binson.ErrorOutOfRange\nreturn\n}\n")
+	g.printf("w.encode(e)\n}\n")
+
+	g.printf("\nfunc (w *%s) encode(e *binson.Encoder) {\n", w)
+	g.printf("e.Begin()\n")
+	for _, f := range fields {
+		if f.Optional {
+			g.printf("if w.has%s {\n", f.goName)
+		}
+		g.printf("e.Name(%s)\n", strconv.Quote(f.Name))
+		g.encodeValue(&f.Value, "w."+f.varName)
+		if f.Optional {
+			g.printf("}\n")
+		}
+	}
+	g.printf("e.End()\n}\n")
+
+	g.printf("\nfunc (w *%s) valid() bool {\n", w)
+	for _, f := range fields {
+		if !needsCheck(&f.Value) {
+			continue
+		}
+		if f.Optional {
+			g.printf("if w.has%s {\n", f.goName)
+		}
+		g.writerChecks(&f.Value, "w."+f.varName)
+		if f.Optional {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return true\n}\n")
+}
+
+func (g *generator) encodeValue(v *Value, expr string) {
+	switch v.Type {
+	case binson.Boolean:
+		g.printf("e.Bool(%s)\n", expr)
+	case binson.Integer:
+		g.printf("e.Integer(%s)\n", expr)
+	case binson.Double:
+		g.printf("e.Double(%s)\n", expr)
+	case binson.String:
+		g.printf("e.String(%s)\n", expr)
+	case binson.Bytes:
+		g.printf("e.Bytes(%s)\n", expr)
+	case binson.Object:
+		g.printf("%s.encode(e)\n", expr)
+	case binson.Array:
+		g.printf("e.BeginArray()\n")
+		g.printf("for i := range %s {\n", expr)
+		g.encodeValue(v.Elem, expr+"[i]")
+		g.printf("}\n")
+		g.printf("e.EndArray()\n")
+	}
+}
+
+// Returns true if values of schema v have bounds that must be checked.
+func needsCheck(v *Value) bool {
+	switch v.Type {
+	case binson.Integer:
+		return v.HasRange && (v.Min != math.MinInt64 || v.Max != math.MaxInt64)
+	case binson.String, binson.Bytes:
+		return v.MinLength > 0 || v.MaxLength > 0
+	case binson.Object:
+		for i := range v.Fields {
+			if needsCheck(&v.Fields[i].Value) {
+				return true
+			}
+		}
+		return false
+	case binson.Array:
+		return v.MinLength > 0 || v.MaxLength > 0 || needsCheck(v.Elem)
+	}
+	return false
+}
+
+// Writes statements that return false if the value expr is out of bounds.
+func (g *generator) writerChecks(v *Value, expr string) {
+	switch v.Type {
+	case binson.Integer, binson.String, binson.Bytes:
+		if cond := boundsCondition(v, expr); cond != "" {
+			g.printf("if %s {\nreturn false\n}\n", cond)
+		}
+	case binson.Object:
+		if needsCheck(v) {
+			g.printf("if !%s.valid() {\nreturn false\n}\n", expr)
+		}
+	case binson.Array:
+		if cond := boundsCondition(v, expr); cond != "" {
+			g.printf("if %s {\nreturn false\n}\n", cond)
+		}
+		if needsCheck(v.Elem) {
+			g.printf("for i := range %s {\n", expr)
+			g.writerChecks(v.Elem, expr+"[i]")
+			g.printf("}\n")
+		}
+	}
+}
+
+// Returns a condition that is true if the integer, or the length of
+// the value expr, is out of bounds. Returns "" if there are no bounds.
+func boundsCondition(v *Value, expr string) string {
+	if v.Type != binson.Integer {
+		return lengthCondition(v, "len("+expr+")")
+	}
+	if !v.HasRange {
+		return ""
+	}
+	var conds []string
+	if v.Min != math.MinInt64 {
+		conds = append(conds, fmt.Sprintf("%s < %d", expr, v.Min))
+	}
+	if v.Max != math.MaxInt64 {
+		conds = append(conds, fmt.Sprintf("%s > %d", expr, v.Max))
+	}
+	return strings.Join(conds, " || ")
+}
+
+// Returns a condition that is true if the length n is out of bounds.
+func lengthCondition(v *Value, n string) string {
+	var conds []string
+	if v.MinLength > 0 {
+		conds = append(conds, fmt.Sprintf("%s < %d", n, v.MinLength))
+	}
+	if v.MaxLength > 0 {
+		conds = append(conds, fmt.Sprintf("%s > %d", n, v.MaxLength))
+	}
+	return strings.Join(conds, " || ")
+}
+
+// ======== Reader ========
+
+func (g *generator) reader(name string, fields []genField) {
+	r := name + "Reader"
+	g.printf("\n// %s reads %s objects.\n", r, name)
+	g.printf("type %s struct {\n", r)
+	for _, f := range fields {
+		switch f.Value.Type {
+		case binson.Object:
+			g.printf("%s %sReader\n", f.varName, f.sub)
+		case binson.Array:
+			g.printf("%s binson.Decoder // before the array\n", f.varName)
+		default:
+			g.printf("%s %s\n", f.varName, readerType(&f.Value, f.sub))
+		}
+		g.printf("has%s bool\n", f.goName)
+	}
+	g.printf("}\n")
+
+	g.printf("\n// Decode reads the object in buf. Returns a binson error code:\n")
+	g.printf("// binson.ErrorUnexpectedType if a field has the wrong type,\n")
+	g.printf("// binson.ErrorOutOfRange if a value is out of range,\n")
+	g.printf("// binson.ErrorMissingField if a required field is missing.\n")
+	g.printf("func (r *%s) Decode(buf []byte) int {\n", r)
+	g.printf("var d binson.Decoder\nd.Init(buf)\nr.decode(&d)\n")
+	g.printf("if d.Error == binson.ErrorNone && d.Offset() != len(buf) {\n")
+	g.printf("return binson.ErrorTrailingBytes\n}\n")
+	g.printf("return d.Error\n}\n")
+
+	g.printf("\n// Reads the fields of the object that d is in.\n")
+	g.printf("func (r *%s) decode(d *binson.Decoder) {\n", r)
+	g.printf("*r = %s{}\n", r)
+	g.printf("for d.NextField() {\n")
+	g.printf("if d.Error != binson.ErrorNone {\nreturn\n}\n")
+	g.printf("switch string(d.Name) {\n")
+	for _, f := range fields {
+		g.printf("case %s:\n", strconv.Quote(f.Name))
+		g.decodeValue(&f.Value, "d", "r."+f.varName, f.sub)
+		g.printf("r.has%s = true\n", f.goName)
+	}
+	g.printf("}\n}\n")
+	g.printf("if d.Error != binson.ErrorNone {\nreturn\n}\n")
+	var missing []string
+	for _, f := range fields {
+		if !f.Optional {
+			missing = append(missing, "!r.has"+f.goName)
+		}
+	}
+	if len(missing) > 0 {
+		g.printf("if %s {\nd.Error = binson.ErrorMissingField\n}\n", strings.Join(missing, " || "))
+	}
+	g.printf("}\n")
+
+	for _, f := range fields {
+		typ := readerType(&f.Value, f.sub)
+		if f.Optional {
+			g.printf("\n// %s returns the %s field, and false if it is missing.\n", f.goName, strconv.Quote(f.Name))
+			g.printf("func (r *%s) %s() (%s, bool) {\n", r, f.goName, typ)
+		} else {
+			g.printf("\n// %s returns the %s field.\n", f.goName, strconv.Quote(f.Name))
+			g.printf("func (r *%s) %s() %s {\n", r, f.goName, typ)
+		}
+		expr := "r." + f.varName
+		switch f.Value.Type {
+		case binson.Object:
+			expr = "&" + expr
+		case binson.Array:
+			g.printf("it := %s{d: r.%s}\nit.d.GoIntoArray()\n", typ, f.varName)
+			expr = "it"
+		}
+		if f.Optional {
+			g.printf("return %s, r.has%s\n}\n", expr, f.goName)
+		} else {
+			g.printf("return %s\n}\n", expr)
+		}
+
+		if f.Value.Type == binson.Array {
+			g.iterator(&f)
+		}
+	}
+}
+
+// Writes statements that read the last value read by the decoder dv
+// into the variable expr, and check it. Statements return if the value
+// is invalid, with the decoder error set.
+func (g *generator) decodeValue(v *Value, dv, expr, sub string) {
+	g.printf("if %s.ValueType != binson.%s {\n", dv, valueTypeName(v.Type))
+	g.printf("%s.Error = binson.ErrorUnexpectedType\nreturn\n}\n", dv)
+
+	check := func(value string) {
+		if cond := boundsCondition(v, value); cond != "" {
+			g.printf("if %s {\n%s.Error = binson.ErrorOutOfRange\nreturn\n}\n", cond, dv)
+		}
+	}
+	switch v.Type {
+	case binson.Boolean:
+		g.printf("%s = %s.ValueBoolean\n", expr, dv)
+	case binson.Integer:
+		check(dv + ".ValueInteger")
+		g.printf("%s = %s.ValueInteger\n", expr, dv)
+	case binson.Double:
+		g.printf("%s = %s.ValueDouble\n", expr, dv)
+	case binson.String, binson.Bytes:
+		check(dv + ".ValueBytes")
+		g.printf("%s = %s.ValueBytes\n", expr, dv)
+	case binson.Object:
+		g.printf("%s.GoIntoObject()\n", dv)
+		g.printf("%s.decode(%s)\n", expr, dv)
+		g.printf("if %s.Error != binson.ErrorNone {\nreturn\n}\n", dv)
+		g.printf("%s.GoUpToObject()\n", dv)
+	case binson.Array:
+		// Keep a copy of the decoder for the iterator, and check
+		// the elements now.
+		g.printf("%s = *%s\n", expr, dv)
+		g.printf("if code := check%s(*%s); code != binson.ErrorNone {\n", sub, dv)
+		g.printf("%s.Error = code\nreturn\n}\n", dv)
+	}
+}
+
+// Generates an iterator over the array field f, and the function that
+// checks the array elements.
+func (g *generator) iterator(f *genField) {
+	it := f.sub + "Iterator"
+	elem := f.Value.Elem
+
+	g.printf("\n// %s iterates over the elements of a %s array.\n", it, strconv.Quote(f.Name))
+	g.printf("type %s struct {\n", it)
+	g.printf("d binson.Decoder\n")
+	if elem.Type == binson.Object {
+		g.printf("value %sReader\n", f.sub)
+	}
+	g.printf("}\n")
+
+	g.printf("\n// Next moves to the next element. Returns false at the end of the array.\n")
+	g.printf("func (it *%s) Next() bool {\n", it)
+	if elem.Type == binson.Object {
+		g.printf("if !it.d.NextArrayValue() {\nreturn false\n}\n")
+		g.printf("it.d.GoIntoObject()\nit.value.decode(&it.d)\nit.d.GoUpToArray()\n")
+		g.printf("return true\n}\n")
+	} else {
+		g.printf("return it.d.NextArrayValue()\n}\n")
+	}
+
+	g.printf("\n// Value returns the current element.\n")
+	g.printf("func (it *%s) Value() %s {\n", it, readerType(elem, f.sub))
+	switch elem.Type {
+	case binson.Boolean:
+		g.printf("return it.d.ValueBoolean\n")
+	case binson.Integer:
+		g.printf("return it.d.ValueInteger\n")
+	case binson.Double:
+		g.printf("return it.d.ValueDouble\n")
+	case binson.String, binson.Bytes:
+		g.printf("return it.d.ValueBytes\n")
+	case binson.Object:
+		g.printf("return &it.value\n")
+	}
+	g.printf("}\n")
+
+	// The decoder is a copy, positioned before the array.
+	g.printf("\n// Checks the elements and the length of a %s array.\n", strconv.Quote(f.Name))
+	g.printf("// Returns a binson error code.\n")
+	g.printf("func check%s(d binson.Decoder) int {\n", f.sub)
+	g.printf("d.GoIntoArray()\nn := 0\n")
+	g.printf("for d.NextArrayValue() {\n")
+	g.printf("if d.Error != binson.ErrorNone {\nreturn d.Error\n}\n")
+	if elem.Type == binson.Object {
+		g.printf("if d.ValueType != binson.Object {\nreturn binson.ErrorUnexpectedType\n}\n")
+		g.printf("var r %sReader\n", f.sub)
+		g.printf("d.GoIntoObject()\nr.decode(&d)\n")
+		g.printf("if d.Error != binson.ErrorNone {\nreturn d.Error\n}\n")
+		g.printf("d.GoUpToArray()\n")
+	} else {
+		g.printf("if d.ValueType != binson.%s {\nreturn binson.ErrorUnexpectedType\n}\n", valueTypeName(elem.Type))
+		value := "d.ValueBytes"
+		if elem.Type == binson.Integer {
+			value = "d.ValueInteger"
+		}
+		if cond := boundsCondition(elem, value); elem.Type != binson.Boolean && elem.Type != binson.Double && cond != "" {
+			g.printf("if %s {\nreturn binson.ErrorOutOfRange\n}\n", cond)
+		}
+	}
+	g.printf("n++\n}\n")
+	g.printf("if d.Error != binson.ErrorNone {\nreturn d.Error\n}\n")
+	if cond := lengthCondition(&f.Value, "n"); cond != "" {
+		g.printf("if %s {\nreturn binson.ErrorOutOfRange\n}\n", cond)
+	}
+	g.printf("return binson.ErrorNone\n}\n")
+}
+
+// Returns the name of the binson constant for t.
+func valueTypeName(t binson.ValueType) string {
+	name := typeName(t)
+	return strings.ToUpper(name[:1]) + name[1:]
+}
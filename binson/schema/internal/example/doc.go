@@ -0,0 +1,5 @@
+// Package example has code generated from example.schema, to test
+// the code generator of package schema.
+package example
+
+//go:generate go run ../../../../cmd/binsongen -type Report -o report.go example.schema
@@ -0,0 +1,152 @@
+package example
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+func newReport() ReportWriter {
+	w := NewReportWriter(17, true, NewReportPosWriter(57.7, 11.9))
+	w.SetName("kitchen")
+	w.SetReadings([]ReportReadingsWriter{
+		NewReportReadingsWriter(100, 21.5),
+		NewReportReadingsWriter(160, 22.0),
+	})
+	w.SetTags([]string{"a", "bc"})
+	return w
+}
+
+func encode(w *ReportWriter) ([]byte, int) {
+	buf := make([]byte, 200)
+	var e binson.Encoder
+	e.Init(buf)
+	w.Encode(&e)
+	return buf[:e.Offset], e.Error
+}
+
+func TestRoundTrip(t *testing.T) {
+	w := newReport()
+	buf, code := encode(&w)
+	if code != binson.ErrorNone {
+		t.Fatalf("encode error %d", code)
+	}
+	if binson.Validate(buf) != binson.ErrorNone {
+		t.Errorf("encoded object is not canonical: %x", buf)
+	}
+
+	var r ReportReader
+	if code := r.Decode(buf); code != binson.ErrorNone {
+		t.Fatalf("decode error %d", code)
+	}
+	if r.Id() != 17 || !r.Ok() || r.Pos().Lat() != 57.7 || r.Pos().Lon() != 11.9 {
+		t.Errorf("unexpected required fields")
+	}
+	if _, ok := r.Pos().Alt(); ok {
+		t.Errorf("alt should be missing")
+	}
+	if name, ok := r.Name(); !ok || string(name) != "kitchen" {
+		t.Errorf("unexpected name %q", name)
+	}
+	if _, ok := r.Raw(); ok {
+		t.Errorf("raw should be missing")
+	}
+
+	readings, ok := r.Readings()
+	var ts []int64
+	for ok && readings.Next() {
+		ts = append(ts, readings.Value().T())
+	}
+	if len(ts) != 2 || ts[0] != 100 || ts[1] != 160 {
+		t.Errorf("unexpected readings %v", ts)
+	}
+
+	tags, ok := r.Tags()
+	var joined []byte
+	for ok && tags.Next() {
+		joined = append(joined, tags.Value()...)
+	}
+	if string(joined) != "abc" {
+		t.Errorf("unexpected tags %q", joined)
+	}
+}
+
+func TestEncodeOutOfRange(t *testing.T) {
+	table := []func(w *ReportWriter){
+		func(w *ReportWriter) { w.SetId(70000) },
+		func(w *ReportWriter) { w.SetName("") },
+		func(w *ReportWriter) { w.SetTags([]string{"a", "b", "c", "d"}) },
+		func(w *ReportWriter) { w.SetTags([]string{"toolongtag"}) },
+		func(w *ReportWriter) {
+			pos := NewReportPosWriter(0, 0)
+			pos.SetAlt(-501)
+			w.SetPos(pos)
+		},
+	}
+
+	for i, f := range table {
+		w := newReport()
+		f(&w)
+		buf, code := encode(&w)
+		if code != binson.ErrorOutOfRange || len(buf) != 0 {
+			t.Errorf("%d: expected ErrorOutOfRange and no output, got %d, %x", i, code, buf)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	w := newReport()
+	valid, _ := encode(&w)
+
+	// Each record replaces a part of the valid object.
+	table := []struct {
+		old, new []byte
+		code     int
+	}{
+		// "id":17 as a string
+		{[]byte("\x10\x11"), []byte("\x14\x01x"), binson.ErrorUnexpectedType},
+		// "id":17 as 70000
+		{[]byte("\x10\x11"), []byte("\x12\x70\x11\x01\x00"), binson.ErrorOutOfRange},
+		// "ok" renamed to "ol"
+		{[]byte("\x14\x02ok"), []byte("\x14\x02ol"), binson.ErrorMissingField},
+		// "lat" renamed to "lau"
+		{[]byte("\x14\x03lat"), []byte("\x14\x03lau"), binson.ErrorMissingField},
+		// "t":100 in a reading as a boolean
+		{[]byte("\x10\x64"), []byte("\x44"), binson.ErrorUnexpectedType},
+		// "bc" tag as an integer
+		{[]byte("\x14\x02bc"), []byte("\x10\x02"), binson.ErrorUnexpectedType},
+		// trailing bytes
+		{[]byte("\x43\x41"), []byte("\x43\x41\x41"), binson.ErrorTrailingBytes},
+	}
+
+	for i, record := range table {
+		if !bytes.Contains(valid, record.old) {
+			t.Fatalf("%d: %x not found", i, record.old)
+		}
+		buf := bytes.Replace(valid, record.old, record.new, 1)
+		var r ReportReader
+		if code := r.Decode(buf); code != record.code {
+			t.Errorf("%d: expected error %d, got %d", i, record.code, code)
+		}
+	}
+}
+
+func TestNoAllocs(t *testing.T) {
+	w := newReport()
+	buf := make([]byte, 200)
+	var e binson.Encoder
+	var r ReportReader
+
+	allocs := testing.AllocsPerRun(100, func() {
+		e.Init(buf)
+		w.Encode(&e)
+		r.Decode(buf[:e.Offset])
+		it, _ := r.Readings()
+		for it.Next() {
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
@@ -0,0 +1,652 @@
+// Code generated by binsongen. DO NOT EDIT.
+
+package example
+
+import "github.com/assaabloy-ppi/binson-go-tiny/binson"
+
+// ReportWriter writes Report objects.
+type ReportWriter struct {
+	id          int64
+	name        string
+	hasName     bool
+	ok          bool
+	pos         ReportPosWriter
+	raw         []byte
+	hasRaw      bool
+	readings    []ReportReadingsWriter
+	hasReadings bool
+	tags        []string
+	hasTags     bool
+}
+
+// NewReportWriter returns a writer with the required fields set.
+func NewReportWriter(id int64, ok bool, pos ReportPosWriter) ReportWriter {
+	return ReportWriter{
+		id:  id,
+		ok:  ok,
+		pos: pos,
+	}
+}
+
+// SetId sets the "id" field.
+func (w *ReportWriter) SetId(v int64) {
+	w.id = v
+}
+
+// SetName sets the "name" field.
+func (w *ReportWriter) SetName(v string) {
+	w.name = v
+	w.hasName = true
+}
+
+// SetOk sets the "ok" field.
+func (w *ReportWriter) SetOk(v bool) {
+	w.ok = v
+}
+
+// SetPos sets the "pos" field.
+func (w *ReportWriter) SetPos(v ReportPosWriter) {
+	w.pos = v
+}
+
+// SetRaw sets the "raw" field.
+func (w *ReportWriter) SetRaw(v []byte) {
+	w.raw = v
+	w.hasRaw = true
+}
+
+// SetReadings sets the "readings" field.
+func (w *ReportWriter) SetReadings(v []ReportReadingsWriter) {
+	w.readings = v
+	w.hasReadings = true
+}
+
+// SetTags sets the "tags" field.
+func (w *ReportWriter) SetTags(v []string) {
+	w.tags = v
+	w.hasTags = true
+}
+
+// Encode writes the object to e. If a value is out of range,
+// nothing is written and e.Error is set to binson.ErrorOutOfRange.
+func (w *ReportWriter) Encode(e *binson.Encoder) {
+	if !w.valid() {
+		e.Error = binson.ErrorOutOfRange
+		return
+	}
+	w.encode(e)
+}
+
+func (w *ReportWriter) encode(e *binson.Encoder) {
+	e.Begin()
+	e.Name("id")
+	e.Integer(w.id)
+	if w.hasName {
+		e.Name("name")
+		e.String(w.name)
+	}
+	e.Name("ok")
+	e.Bool(w.ok)
+	e.Name("pos")
+	w.pos.encode(e)
+	if w.hasRaw {
+		e.Name("raw")
+		e.Bytes(w.raw)
+	}
+	if w.hasReadings {
+		e.Name("readings")
+		e.BeginArray()
+		for i := range w.readings {
+			w.readings[i].encode(e)
+		}
+		e.EndArray()
+	}
+	if w.hasTags {
+		e.Name("tags")
+		e.BeginArray()
+		for i := range w.tags {
+			e.String(w.tags[i])
+		}
+		e.EndArray()
+	}
+	e.End()
+}
+
+func (w *ReportWriter) valid() bool {
+	if w.id < 0 || w.id > 65535 {
+		return false
+	}
+	if w.hasName {
+		if len(w.name) < 1 || len(w.name) > 16 {
+			return false
+		}
+	}
+	if !w.pos.valid() {
+		return false
+	}
+	if w.hasReadings {
+		if len(w.readings) > 4 {
+			return false
+		}
+	}
+	if w.hasTags {
+		if len(w.tags) > 3 {
+			return false
+		}
+		for i := range w.tags {
+			if len(w.tags[i]) > 8 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ReportReader reads Report objects.
+type ReportReader struct {
+	id          int64
+	hasId       bool
+	name        []byte
+	hasName     bool
+	ok          bool
+	hasOk       bool
+	pos         ReportPosReader
+	hasPos      bool
+	raw         []byte
+	hasRaw      bool
+	readings    binson.Decoder // before the array
+	hasReadings bool
+	tags        binson.Decoder // before the array
+	hasTags     bool
+}
+
+// Decode reads the object in buf. Returns a binson error code:
+// binson.ErrorUnexpectedType if a field has the wrong type,
+// binson.ErrorOutOfRange if a value is out of range,
+// binson.ErrorMissingField if a required field is missing.
+func (r *ReportReader) Decode(buf []byte) int {
+	var d binson.Decoder
+	d.Init(buf)
+	r.decode(&d)
+	if d.Error == binson.ErrorNone && d.Offset() != len(buf) {
+		return binson.ErrorTrailingBytes
+	}
+	return d.Error
+}
+
+// Reads the fields of the object that d is in.
+func (r *ReportReader) decode(d *binson.Decoder) {
+	*r = ReportReader{}
+	for d.NextField() {
+		if d.Error != binson.ErrorNone {
+			return
+		}
+		switch string(d.Name) {
+		case "id":
+			if d.ValueType != binson.Integer {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			if d.ValueInteger < 0 || d.ValueInteger > 65535 {
+				d.Error = binson.ErrorOutOfRange
+				return
+			}
+			r.id = d.ValueInteger
+			r.hasId = true
+		case "name":
+			if d.ValueType != binson.String {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			if len(d.ValueBytes) < 1 || len(d.ValueBytes) > 16 {
+				d.Error = binson.ErrorOutOfRange
+				return
+			}
+			r.name = d.ValueBytes
+			r.hasName = true
+		case "ok":
+			if d.ValueType != binson.Boolean {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			r.ok = d.ValueBoolean
+			r.hasOk = true
+		case "pos":
+			if d.ValueType != binson.Object {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			d.GoIntoObject()
+			r.pos.decode(d)
+			if d.Error != binson.ErrorNone {
+				return
+			}
+			d.GoUpToObject()
+			r.hasPos = true
+		case "raw":
+			if d.ValueType != binson.Bytes {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			r.raw = d.ValueBytes
+			r.hasRaw = true
+		case "readings":
+			if d.ValueType != binson.Array {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			r.readings = *d
+			if code := checkReportReadings(*d); code != binson.ErrorNone {
+				d.Error = code
+				return
+			}
+			r.hasReadings = true
+		case "tags":
+			if d.ValueType != binson.Array {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			r.tags = *d
+			if code := checkReportTags(*d); code != binson.ErrorNone {
+				d.Error = code
+				return
+			}
+			r.hasTags = true
+		}
+	}
+	if d.Error != binson.ErrorNone {
+		return
+	}
+	if !r.hasId || !r.hasOk || !r.hasPos {
+		d.Error = binson.ErrorMissingField
+	}
+}
+
+// Id returns the "id" field.
+func (r *ReportReader) Id() int64 {
+	return r.id
+}
+
+// Name returns the "name" field, and false if it is missing.
+func (r *ReportReader) Name() ([]byte, bool) {
+	return r.name, r.hasName
+}
+
+// Ok returns the "ok" field.
+func (r *ReportReader) Ok() bool {
+	return r.ok
+}
+
+// Pos returns the "pos" field.
+func (r *ReportReader) Pos() *ReportPosReader {
+	return &r.pos
+}
+
+// Raw returns the "raw" field, and false if it is missing.
+func (r *ReportReader) Raw() ([]byte, bool) {
+	return r.raw, r.hasRaw
+}
+
+// Readings returns the "readings" field, and false if it is missing.
+func (r *ReportReader) Readings() (ReportReadingsIterator, bool) {
+	it := ReportReadingsIterator{d: r.readings}
+	it.d.GoIntoArray()
+	return it, r.hasReadings
+}
+
+// ReportReadingsIterator iterates over the elements of a "readings" array.
+type ReportReadingsIterator struct {
+	d     binson.Decoder
+	value ReportReadingsReader
+}
+
+// Next moves to the next element. Returns false at the end of the array.
+func (it *ReportReadingsIterator) Next() bool {
+	if !it.d.NextArrayValue() {
+		return false
+	}
+	it.d.GoIntoObject()
+	it.value.decode(&it.d)
+	it.d.GoUpToArray()
+	return true
+}
+
+// Value returns the current element.
+func (it *ReportReadingsIterator) Value() *ReportReadingsReader {
+	return &it.value
+}
+
+// Checks the elements and the length of a "readings" array.
+// Returns a binson error code.
+func checkReportReadings(d binson.Decoder) int {
+	d.GoIntoArray()
+	n := 0
+	for d.NextArrayValue() {
+		if d.Error != binson.ErrorNone {
+			return d.Error
+		}
+		if d.ValueType != binson.Object {
+			return binson.ErrorUnexpectedType
+		}
+		var r ReportReadingsReader
+		d.GoIntoObject()
+		r.decode(&d)
+		if d.Error != binson.ErrorNone {
+			return d.Error
+		}
+		d.GoUpToArray()
+		n++
+	}
+	if d.Error != binson.ErrorNone {
+		return d.Error
+	}
+	if n > 4 {
+		return binson.ErrorOutOfRange
+	}
+	return binson.ErrorNone
+}
+
+// Tags returns the "tags" field, and false if it is missing.
+func (r *ReportReader) Tags() (ReportTagsIterator, bool) {
+	it := ReportTagsIterator{d: r.tags}
+	it.d.GoIntoArray()
+	return it, r.hasTags
+}
+
+// ReportTagsIterator iterates over the elements of a "tags" array.
+type ReportTagsIterator struct {
+	d binson.Decoder
+}
+
+// Next moves to the next element. Returns false at the end of the array.
+func (it *ReportTagsIterator) Next() bool {
+	return it.d.NextArrayValue()
+}
+
+// Value returns the current element.
+func (it *ReportTagsIterator) Value() []byte {
+	return it.d.ValueBytes
+}
+
+// Checks the elements and the length of a "tags" array.
+// Returns a binson error code.
+func checkReportTags(d binson.Decoder) int {
+	d.GoIntoArray()
+	n := 0
+	for d.NextArrayValue() {
+		if d.Error != binson.ErrorNone {
+			return d.Error
+		}
+		if d.ValueType != binson.String {
+			return binson.ErrorUnexpectedType
+		}
+		if len(d.ValueBytes) > 8 {
+			return binson.ErrorOutOfRange
+		}
+		n++
+	}
+	if d.Error != binson.ErrorNone {
+		return d.Error
+	}
+	if n > 3 {
+		return binson.ErrorOutOfRange
+	}
+	return binson.ErrorNone
+}
+
+// ReportPosWriter writes ReportPos objects.
+type ReportPosWriter struct {
+	alt    int64
+	hasAlt bool
+	lat    float64
+	lon    float64
+}
+
+// NewReportPosWriter returns a writer with the required fields set.
+func NewReportPosWriter(lat float64, lon float64) ReportPosWriter {
+	return ReportPosWriter{
+		lat: lat,
+		lon: lon,
+	}
+}
+
+// SetAlt sets the "alt" field.
+func (w *ReportPosWriter) SetAlt(v int64) {
+	w.alt = v
+	w.hasAlt = true
+}
+
+// SetLat sets the "lat" field.
+func (w *ReportPosWriter) SetLat(v float64) {
+	w.lat = v
+}
+
+// SetLon sets the "lon" field.
+func (w *ReportPosWriter) SetLon(v float64) {
+	w.lon = v
+}
+
+// Encode writes the object to e. If a value is out of range,
+// nothing is written and e.Error is set to binson.ErrorOutOfRange.
+func (w *ReportPosWriter) Encode(e *binson.Encoder) {
+	if !w.valid() {
+		e.Error = binson.ErrorOutOfRange
+		return
+	}
+	w.encode(e)
+}
+
+func (w *ReportPosWriter) encode(e *binson.Encoder) {
+	e.Begin()
+	if w.hasAlt {
+		e.Name("alt")
+		e.Integer(w.alt)
+	}
+	e.Name("lat")
+	e.Double(w.lat)
+	e.Name("lon")
+	e.Double(w.lon)
+	e.End()
+}
+
+func (w *ReportPosWriter) valid() bool {
+	if w.hasAlt {
+		if w.alt < -500 || w.alt > 9000 {
+			return false
+		}
+	}
+	return true
+}
+
+// ReportPosReader reads ReportPos objects.
+type ReportPosReader struct {
+	alt    int64
+	hasAlt bool
+	lat    float64
+	hasLat bool
+	lon    float64
+	hasLon bool
+}
+
+// Decode reads the object in buf. Returns a binson error code:
+// binson.ErrorUnexpectedType if a field has the wrong type,
+// binson.ErrorOutOfRange if a value is out of range,
+// binson.ErrorMissingField if a required field is missing.
+func (r *ReportPosReader) Decode(buf []byte) int {
+	var d binson.Decoder
+	d.Init(buf)
+	r.decode(&d)
+	if d.Error == binson.ErrorNone && d.Offset() != len(buf) {
+		return binson.ErrorTrailingBytes
+	}
+	return d.Error
+}
+
+// Reads the fields of the object that d is in.
+func (r *ReportPosReader) decode(d *binson.Decoder) {
+	*r = ReportPosReader{}
+	for d.NextField() {
+		if d.Error != binson.ErrorNone {
+			return
+		}
+		switch string(d.Name) {
+		case "alt":
+			if d.ValueType != binson.Integer {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			if d.ValueInteger < -500 || d.ValueInteger > 9000 {
+				d.Error = binson.ErrorOutOfRange
+				return
+			}
+			r.alt = d.ValueInteger
+			r.hasAlt = true
+		case "lat":
+			if d.ValueType != binson.Double {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			r.lat = d.ValueDouble
+			r.hasLat = true
+		case "lon":
+			if d.ValueType != binson.Double {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			r.lon = d.ValueDouble
+			r.hasLon = true
+		}
+	}
+	if d.Error != binson.ErrorNone {
+		return
+	}
+	if !r.hasLat || !r.hasLon {
+		d.Error = binson.ErrorMissingField
+	}
+}
+
+// Alt returns the "alt" field, and false if it is missing.
+func (r *ReportPosReader) Alt() (int64, bool) {
+	return r.alt, r.hasAlt
+}
+
+// Lat returns the "lat" field.
+func (r *ReportPosReader) Lat() float64 {
+	return r.lat
+}
+
+// Lon returns the "lon" field.
+func (r *ReportPosReader) Lon() float64 {
+	return r.lon
+}
+
+// ReportReadingsWriter writes ReportReadings objects.
+type ReportReadingsWriter struct {
+	t     int64
+	tempC float64
+}
+
+// NewReportReadingsWriter returns a writer with the required fields set.
+func NewReportReadingsWriter(t int64, tempC float64) ReportReadingsWriter {
+	return ReportReadingsWriter{
+		t:     t,
+		tempC: tempC,
+	}
+}
+
+// SetT sets the "t" field.
+func (w *ReportReadingsWriter) SetT(v int64) {
+	w.t = v
+}
+
+// SetTempC sets the "temp_c" field.
+func (w *ReportReadingsWriter) SetTempC(v float64) {
+	w.tempC = v
+}
+
+// Encode writes the object to e. If a value is out of range,
+// nothing is written and e.Error is set to binson.ErrorOutOfRange.
+func (w *ReportReadingsWriter) Encode(e *binson.Encoder) {
+	if !w.valid() {
+		e.Error = binson.ErrorOutOfRange
+		return
+	}
+	w.encode(e)
+}
+
+func (w *ReportReadingsWriter) encode(e *binson.Encoder) {
+	e.Begin()
+	e.Name("t")
+	e.Integer(w.t)
+	e.Name("temp_c")
+	e.Double(w.tempC)
+	e.End()
+}
+
+func (w *ReportReadingsWriter) valid() bool {
+	return true
+}
+
+// ReportReadingsReader reads ReportReadings objects.
+type ReportReadingsReader struct {
+	t        int64
+	hasT     bool
+	tempC    float64
+	hasTempC bool
+}
+
+// Decode reads the object in buf. Returns a binson error code:
+// binson.ErrorUnexpectedType if a field has the wrong type,
+// binson.ErrorOutOfRange if a value is out of range,
+// binson.ErrorMissingField if a required field is missing.
+func (r *ReportReadingsReader) Decode(buf []byte) int {
+	var d binson.Decoder
+	d.Init(buf)
+	r.decode(&d)
+	if d.Error == binson.ErrorNone && d.Offset() != len(buf) {
+		return binson.ErrorTrailingBytes
+	}
+	return d.Error
+}
+
+// Reads the fields of the object that d is in.
+func (r *ReportReadingsReader) decode(d *binson.Decoder) {
+	*r = ReportReadingsReader{}
+	for d.NextField() {
+		if d.Error != binson.ErrorNone {
+			return
+		}
+		switch string(d.Name) {
+		case "t":
+			if d.ValueType != binson.Integer {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			r.t = d.ValueInteger
+			r.hasT = true
+		case "temp_c":
+			if d.ValueType != binson.Double {
+				d.Error = binson.ErrorUnexpectedType
+				return
+			}
+			r.tempC = d.ValueDouble
+			r.hasTempC = true
+		}
+	}
+	if d.Error != binson.ErrorNone {
+		return
+	}
+	if !r.hasT || !r.hasTempC {
+		d.Error = binson.ErrorMissingField
+	}
+}
+
+// T returns the "t" field.
+func (r *ReportReadingsReader) T() int64 {
+	return r.t
+}
+
+// TempC returns the "temp_c" field.
+func (r *ReportReadingsReader) TempC() float64 {
+	return r.tempC
+}
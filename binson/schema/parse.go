@@ -0,0 +1,372 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// ======== Text description ========
+
+// Parse parses a text description of a schema, see the package doc.
+func Parse(text string) (*Value, error) {
+	p := parser{text: text, line: 1}
+	p.next()
+	s, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %q after schema", p.tok)
+	}
+	return &s, nil
+}
+
+type parser struct {
+	text string
+	pos  int
+	line int
+	tok  string // current token, "" at end of text
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("schema: line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// Reads the next token into p.tok. Tokens are separated by white space
+// and comments. Braces are tokens by themselves. A quoted name, with an
+// optional ? after it, is one token.
+func (p *parser) next() {
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		if c == '#' {
+			for p.pos < len(p.text) && p.text[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			break
+		}
+		if c == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+
+	start := p.pos
+	switch {
+	case p.pos == len(p.text):
+	case p.text[p.pos] == '{' || p.text[p.pos] == '}':
+		p.pos++
+	case p.text[p.pos] == '"':
+		p.pos++
+		for p.pos < len(p.text) && p.text[p.pos] != '"' && p.text[p.pos] != '\n' {
+			if p.text[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		p.pos++ // closing quote
+		if p.pos < len(p.text) && p.text[p.pos] == '?' {
+			p.pos++
+		}
+		if p.pos > len(p.text) {
+			p.pos = len(p.text)
+		}
+	default:
+		for p.pos < len(p.text) && !strings.ContainsRune(" \t\r\n{}#", rune(p.text[p.pos])) {
+			p.pos++
+		}
+	}
+	p.tok = p.text[start:p.pos]
+}
+
+func (p *parser) value() (Value, error) {
+	var s Value
+	typ := p.tok
+	p.next()
+
+	switch typ {
+	case "any":
+		s.Any = true
+	case "boolean":
+		s.Type = binson.Boolean
+	case "double":
+		s.Type = binson.Double
+	case "integer":
+		s.Type = binson.Integer
+		if isRange(p.tok) {
+			var err error
+			if s.Min, s.Max, err = p.intRange(math.MinInt64, math.MaxInt64); err != nil {
+				return s, err
+			}
+			s.HasRange = true
+		}
+	case "string", "bytes", "array":
+		s.Type = binson.String
+		if typ == "bytes" {
+			s.Type = binson.Bytes
+		}
+		if isRange(p.tok) {
+			if err := p.lengthRange(&s); err != nil {
+				return s, err
+			}
+		}
+		if typ == "array" {
+			s.Type = binson.Array
+			elem, err := p.value()
+			if err != nil {
+				return s, err
+			}
+			if !elem.Any {
+				s.Elem = &elem
+			}
+		}
+	case "object":
+		s.Type = binson.Object
+		if err := p.fields(&s); err != nil {
+			return s, err
+		}
+	case "":
+		return s, p.errorf("unexpected end of schema")
+	default:
+		return s, p.errorf("unknown type %q", typ)
+	}
+	return s, nil
+}
+
+// Parses the fields of an object schema, in braces.
+func (p *parser) fields(s *Value) error {
+	if p.tok != "{" {
+		return p.errorf("expected { after object")
+	}
+	p.next()
+
+	for p.tok != "}" {
+		if p.tok == "" || p.tok == "{" {
+			return p.errorf("expected field name or }")
+		}
+		if p.tok == "..." {
+			s.Open = true
+			p.next()
+			continue
+		}
+
+		var f Field
+		name := p.tok
+		if strings.HasSuffix(name, "?") {
+			f.Optional = true
+			name = name[:len(name)-1]
+		}
+		if strings.HasPrefix(name, `"`) {
+			var err error
+			if name, err = strconv.Unquote(name); err != nil {
+				return p.errorf("invalid quoted name %s", p.tok)
+			}
+		}
+		if name == "" {
+			return p.errorf("empty field name")
+		}
+		if s.field(name) >= 0 {
+			return p.errorf("duplicate field %q", name)
+		}
+		f.Name = name
+		p.next()
+
+		var err error
+		if f.Value, err = p.value(); err != nil {
+			return err
+		}
+		s.Fields = append(s.Fields, f)
+	}
+	p.next()
+	return nil
+}
+
+func isRange(tok string) bool {
+	return tok != "..." && strings.Contains(tok, "..")
+}
+
+// Parses the range in the current token. Left out bounds are set
+// to min and max.
+func (p *parser) intRange(min, max int64) (int64, int64, error) {
+	lo, hi, _ := strings.Cut(p.tok, "..")
+	var err error
+	if lo != "" {
+		if min, err = strconv.ParseInt(lo, 10, 64); err != nil {
+			return 0, 0, p.errorf("invalid range %q", p.tok)
+		}
+	}
+	if hi != "" {
+		if max, err = strconv.ParseInt(hi, 10, 64); err != nil {
+			return 0, 0, p.errorf("invalid range %q", p.tok)
+		}
+	}
+	if min > max {
+		return 0, 0, p.errorf("empty range %q", p.tok)
+	}
+	p.next()
+	return min, max, nil
+}
+
+// Parses the length range in the current token into s.
+func (p *parser) lengthRange(s *Value) error {
+	tok := p.tok
+	min, max, err := p.intRange(0, math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	if min < 0 || max <= 0 || max > math.MaxInt32 {
+		return p.errorf("invalid length range %q", tok)
+	}
+	s.MinLength = int(min)
+	if !strings.HasSuffix(tok, "..") {
+		s.MaxLength = int(max)
+	}
+	return nil
+}
+
+// ======== Binson description ========
+
+// ParseBinson parses a schema described as a Binson object. Each value
+// schema is an object with the fields below; all but type are optional.
+//
+//	type       string, a type name as in text descriptions
+//	min, max   integer, range of integers
+//	minLength  integer, bounds of lengths
+//	maxLength  integer, 0 means no upper bound
+//	fields     object, with a value schema for each field of an object
+//	open       boolean, objects may have other fields
+//	elements   object, value schema of array elements
+//	optional   boolean, in field schemas only
+func ParseBinson(buf []byte) (*Value, error) {
+	var d binson.Decoder
+	d.Init(buf)
+	s, _, err := decodeValue(&d, "")
+	if err != nil {
+		return nil, err
+	}
+	if d.Error != binson.ErrorNone || d.Offset() != len(buf) {
+		return nil, errors.New("schema: description is not a Binson object")
+	}
+	return &s, nil
+}
+
+// Decodes the value schema object that d is in. Returns the schema and
+// the optional flag.
+func decodeValue(d *binson.Decoder, path string) (Value, bool, error) {
+	var s Value
+	optional, hasMin, hasMax, hasType := false, false, false, false
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("schema: %s: %s", pathOrRoot(path), fmt.Sprintf(format, args...))
+	}
+
+	s.Min, s.Max = math.MinInt64, math.MaxInt64
+	for d.NextField() {
+		name := string(d.Name)
+		expected := binson.Integer
+		switch name {
+		case "type":
+			expected = binson.String
+		case "open", "optional":
+			expected = binson.Boolean
+		case "fields", "elements":
+			expected = binson.Object
+		case "min", "max", "minLength", "maxLength":
+		default:
+			return s, false, errorf("unknown key %q", name)
+		}
+		if d.ValueType != expected {
+			return s, false, errorf("%s is not %s", name, typeName(expected))
+		}
+
+		switch name {
+		case "type":
+			hasType = true
+			if string(d.ValueBytes) == "any" {
+				s.Any = true
+				break
+			}
+			t := -1
+			for i, typeName := range typeNames {
+				if typeName == string(d.ValueBytes) {
+					t = i
+				}
+			}
+			if t < 0 {
+				return s, false, errorf("unknown type %q", d.ValueBytes)
+			}
+			s.Type = binson.ValueType(t)
+		case "open":
+			s.Open = d.ValueBoolean
+		case "optional":
+			optional = d.ValueBoolean
+		case "min":
+			s.Min, hasMin = d.ValueInteger, true
+		case "max":
+			s.Max, hasMax = d.ValueInteger, true
+		case "minLength", "maxLength":
+			if d.ValueInteger < 0 || d.ValueInteger > math.MaxInt32 {
+				return s, false, errorf("invalid %s", name)
+			}
+			if name == "minLength" {
+				s.MinLength = int(d.ValueInteger)
+			} else {
+				s.MaxLength = int(d.ValueInteger)
+			}
+		case "fields":
+			d.GoIntoObject()
+			for d.NextField() {
+				if d.ValueType != binson.Object {
+					return s, false, errorf("field %q is not an object", d.Name)
+				}
+				f := Field{Name: string(d.Name)}
+				d.GoIntoObject()
+				var err error
+				if f.Value, f.Optional, err = decodeValue(d, joinName(path, f.Name)); err != nil {
+					return s, false, err
+				}
+				d.GoUpToObject()
+				s.Fields = append(s.Fields, f)
+			}
+			d.GoUpToObject()
+		case "elements":
+			d.GoIntoObject()
+			elem, _, err := decodeValue(d, path+"[]")
+			if err != nil {
+				return s, false, err
+			}
+			d.GoUpToObject()
+			if !elem.Any {
+				s.Elem = &elem
+			}
+		}
+	}
+	if d.Error != binson.ErrorNone {
+		return s, false, errors.New("schema: description is not a Binson object")
+	}
+
+	if !hasType {
+		return s, false, errorf("type missing")
+	}
+	s.HasRange = hasMin || hasMax
+	if !s.HasRange {
+		s.Min, s.Max = 0, 0
+	}
+	if s.Min > s.Max {
+		return s, false, errorf("empty range %d..%d", s.Min, s.Max)
+	}
+	return s, optional, nil
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
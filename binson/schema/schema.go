@@ -0,0 +1,253 @@
+// Package schema describes the contents of Binson messages and
+// validates messages against such descriptions.
+//
+// A schema is a tree of Value structs. It can be written in Go, or
+// parsed from a text description with Parse, or from a Binson
+// description with ParseBinson. The text description of a message with
+// an id, an optional name and a position:
+//
+//	object {
+//	    id     integer 0..65535
+//	    name?  string 1..32          # length in bytes
+//	    pos    object { lat double  lon double }
+//	    tags?  array ..8 string ..16 # at most 8 strings
+//	    meta?  any
+//	    ...                          # other fields are allowed
+//	}
+//
+// Value types are boolean, integer, double, string, bytes, array,
+// object and any. A range a..b after integer limits the value, after
+// string, bytes and array it limits the length. Either bound of a range
+// may be left out. An array is followed by the schema of its elements.
+// A field name ending with ? is optional. Names with spaces or other
+// special characters are written as Go string literals, like "a b"?.
+//
+// Generate writes Go code with typed writers and readers for a schema,
+// see also the command binsongen.
+//
+// Parsing allocates the Value tree. Validate allocates the paths and
+// messages of the violations, and per object a slice that marks the
+// fields seen. The code written by Generate does not allocate.
+package schema
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// A Value is the schema of a Binson value.
+type Value struct {
+	Type binson.ValueType
+	Any  bool // any value is valid, the other fields are ignored
+
+	// Range of integers. Checked only if HasRange is true.
+	HasRange bool
+	Min, Max int64
+
+	// Bounds of the length of strings and bytes in bytes, and of arrays
+	// in elements. MaxLength 0 means no upper bound.
+	MinLength, MaxLength int
+
+	Fields []Field // fields of objects
+	Open   bool    // objects may have fields that are not in Fields
+	Elem   *Value  // schema of array elements, nil means any value
+}
+
+// A Field is the schema of an object field.
+type Field struct {
+	Name     string
+	Optional bool
+	Value    Value
+}
+
+// A Violation is a difference between a message and its schema.
+// Path is the location of the value in the message, such as "pos.lat"
+// or "tags[2]", and "" for the top-level object.
+type Violation struct {
+	Path    string
+	Message string
+}
+
+func (v Violation) Error() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// Names of value types, as used in text descriptions.
+var typeNames = [...]string{
+	binson.Boolean: "boolean",
+	binson.Integer: "integer",
+	binson.Double:  "double",
+	binson.String:  "string",
+	binson.Bytes:   "bytes",
+	binson.Array:   "array",
+	binson.Object:  "object",
+}
+
+func typeName(t binson.ValueType) string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return "type " + strconv.Itoa(int(t))
+}
+
+// ======== Validation ========
+
+// Validate checks the Binson object in buf against the schema s, which
+// must be an object schema. Returns all violations, or nil if the
+// object is valid. If buf is not a well-formed Binson object, the
+// violations found before the error are returned, followed by one
+// for the error.
+func (s *Value) Validate(buf []byte) []Violation {
+	v := validator{}
+	v.d.Init(buf)
+	if !s.Any && s.Type != binson.Object {
+		v.add("", "schema is not an object schema")
+		return v.violations
+	}
+
+	v.object(s, "")
+	if v.d.Error != binson.ErrorNone {
+		v.add(v.errorPath, "malformed Binson, error code "+strconv.Itoa(v.d.Error))
+	} else if v.d.Offset() != len(buf) {
+		v.add("", "trailing bytes after object")
+	}
+	return v.violations
+}
+
+type validator struct {
+	d          binson.Decoder
+	violations []Violation
+	errorPath  string // path of the object or array where d failed
+}
+
+func (v *validator) add(path, message string) {
+	v.violations = append(v.violations, Violation{Path: path, Message: message})
+}
+
+// Checks the fields of the object that d is in, up to the end
+// of the object.
+func (v *validator) object(s *Value, path string) {
+	seen := make([]bool, len(s.Fields))
+	for v.d.NextField() {
+		if v.d.Error != binson.ErrorNone {
+			break
+		}
+		name := string(v.d.Name)
+		fieldPath := joinName(path, name)
+
+		i := s.field(name)
+		switch {
+		case s.Any:
+		case i < 0:
+			if !s.Open {
+				v.add(fieldPath, "unexpected field")
+			}
+		case seen[i]:
+			v.add(fieldPath, "duplicate field")
+		default:
+			seen[i] = true
+			v.value(&s.Fields[i].Value, fieldPath, false)
+		}
+	}
+	if v.d.Error != binson.ErrorNone {
+		if v.errorPath == "" {
+			v.errorPath = path
+		}
+		return
+	}
+
+	for i := range s.Fields {
+		if !seen[i] && !s.Fields[i].Optional && !s.Any {
+			v.add(joinName(path, s.Fields[i].Name), "missing required field")
+		}
+	}
+}
+
+// Checks the last value read by d. Objects and arrays are checked
+// recursively, d is then moved up to the parent, which is an array
+// if inArray is true.
+func (v *validator) value(s *Value, path string, inArray bool) {
+	d := &v.d
+	if s.Any {
+		return // nested objects and arrays are skipped by d
+	}
+	if d.ValueType != s.Type {
+		v.add(path, "expected "+typeName(s.Type)+", got "+typeName(d.ValueType))
+		return
+	}
+
+	switch d.ValueType {
+	case binson.Integer:
+		if s.HasRange && (d.ValueInteger < s.Min || d.ValueInteger > s.Max) {
+			v.add(path, fmt.Sprintf("integer %d out of range %d..%d", d.ValueInteger, s.Min, s.Max))
+		}
+	case binson.String, binson.Bytes:
+		v.length(s, path, len(d.ValueBytes))
+	case binson.Object:
+		d.GoIntoObject()
+		v.object(s, path)
+		if d.Error == binson.ErrorNone {
+			goUp(d, inArray)
+		}
+	case binson.Array:
+		d.GoIntoArray()
+		n := 0
+		for d.NextArrayValue() {
+			if d.Error != binson.ErrorNone {
+				break
+			}
+			if s.Elem != nil {
+				v.value(s.Elem, path+"["+strconv.Itoa(n)+"]", true)
+			}
+			n++
+		}
+		if d.Error != binson.ErrorNone {
+			if v.errorPath == "" {
+				v.errorPath = path
+			}
+			return
+		}
+		v.length(s, path, n)
+		goUp(d, inArray)
+	}
+}
+
+func (v *validator) length(s *Value, path string, n int) {
+	if n < s.MinLength || (s.MaxLength > 0 && n > s.MaxLength) {
+		bounds := strconv.Itoa(s.MinLength) + ".."
+		if s.MaxLength > 0 {
+			bounds += strconv.Itoa(s.MaxLength)
+		}
+		v.add(path, "length "+strconv.Itoa(n)+" out of range "+bounds)
+	}
+}
+
+// Returns the index of the field with the given name, or -1.
+func (s *Value) field(name string) int {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func joinName(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func goUp(d *binson.Decoder, inArray bool) {
+	if inArray {
+		d.GoUpToArray()
+	} else {
+		d.GoUpToObject()
+	}
+}
@@ -0,0 +1,338 @@
+package schema
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	goparser "go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+const positionText = `
+# A position report.
+object {
+    id     integer 0..65535
+    name?  string 1..8
+    pos    object { lat double  lon double }
+    tags?  array ..2 string ..4
+    "x y"? any
+}`
+
+func positionSchema(t *testing.T) *Value {
+	s, err := Parse(positionText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func encode(f func(e *binson.Encoder)) []byte {
+	buf := make([]byte, 1000)
+	var e binson.Encoder
+	e.Init(buf)
+	e.Begin()
+	f(&e)
+	e.End()
+	return buf[:e.Offset]
+}
+
+func violationStrings(violations []Violation) []string {
+	var s []string
+	for _, v := range violations {
+		s = append(s, v.Error())
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	s := positionSchema(t)
+	exp := &Value{
+		Type: binson.Object,
+		Fields: []Field{
+			{Name: "id", Value: Value{Type: binson.Integer, HasRange: true, Min: 0, Max: 65535}},
+			{Name: "name", Optional: true, Value: Value{Type: binson.String, MinLength: 1, MaxLength: 8}},
+			{Name: "pos", Value: Value{Type: binson.Object, Fields: []Field{
+				{Name: "lat", Value: Value{Type: binson.Double}},
+				{Name: "lon", Value: Value{Type: binson.Double}},
+			}}},
+			{Name: "tags", Optional: true, Value: Value{Type: binson.Array, MaxLength: 2,
+				Elem: &Value{Type: binson.String, MaxLength: 4}}},
+			{Name: "x y", Optional: true, Value: Value{Any: true}},
+		},
+	}
+	if !reflect.DeepEqual(s, exp) {
+		t.Errorf("unexpected schema\n%+v\nexpected\n%+v", s, exp)
+	}
+}
+
+func TestParseBinson(t *testing.T) {
+	field := func(e *binson.Encoder, name string, f func()) {
+		e.FieldBeginObject(name)
+		f()
+		e.End()
+	}
+	desc := encode(func(e *binson.Encoder) {
+		field(e, "fields", func() {
+			field(e, "id", func() {
+				e.FieldInt("max", 65535)
+				e.FieldInt("min", 0)
+				e.FieldString("type", "integer")
+			})
+			field(e, "name", func() {
+				e.FieldInt("maxLength", 8)
+				e.FieldInt("minLength", 1)
+				e.FieldBool("optional", true)
+				e.FieldString("type", "string")
+			})
+			field(e, "pos", func() {
+				field(e, "fields", func() {
+					field(e, "lat", func() { e.FieldString("type", "double") })
+					field(e, "lon", func() { e.FieldString("type", "double") })
+				})
+				e.FieldString("type", "object")
+			})
+			field(e, "tags", func() {
+				field(e, "elements", func() {
+					e.FieldInt("maxLength", 4)
+					e.FieldString("type", "string")
+				})
+				e.FieldInt("maxLength", 2)
+				e.FieldBool("optional", true)
+				e.FieldString("type", "array")
+			})
+			field(e, "x y", func() {
+				e.FieldBool("optional", true)
+				e.FieldString("type", "any")
+			})
+		})
+		e.FieldString("type", "object")
+	})
+
+	s, err := ParseBinson(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := positionSchema(t); !reflect.DeepEqual(s, exp) {
+		t.Errorf("unexpected schema\n%+v\nexpected\n%+v", s, exp)
+	}
+
+	bad := encode(func(e *binson.Encoder) {
+		e.FieldString("type", "integer")
+		e.FieldString("unit", "m")
+	})
+	if _, err := ParseBinson(bad); err == nil || !strings.Contains(err.Error(), "unit") {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	table := []struct {
+		text  string
+		error string
+	}{
+		{"", "line 1: unexpected end of schema"},
+		{"object", "line 1: expected { after object"},
+		{"object {\n a int }", "line 2: unknown type \"int\""},
+		{"object { a integer 5..1 }", "empty range"},
+		{"object { a string ..0 }", "invalid length range"},
+		{"object { a string -1.. }", "invalid length range"},
+		{"object { a integer x..1 }", "invalid range"},
+		{"object { a boolean a double }", "duplicate field \"a\""},
+		{"object { a array }", "unknown type \"}\""},
+		{"object { \"a\n\" boolean }", "invalid quoted name"},
+		{"object { a boolean", "expected field name or }"},
+		{"object { } boolean", "unexpected \"boolean\" after schema"},
+	}
+
+	for _, record := range table {
+		_, err := Parse(record.text)
+		if err == nil || !strings.Contains(err.Error(), record.error) {
+			t.Errorf("%q: expected error %q, got %v", record.text, record.error, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := positionSchema(t)
+
+	valid := encode(func(e *binson.Encoder) {
+		e.FieldInt("id", 7)
+		e.FieldString("name", "north")
+		e.FieldBeginObject("pos")
+		e.FieldDouble("lat", 57.7)
+		e.FieldDouble("lon", 11.9)
+		e.End()
+		e.FieldBeginArray("tags")
+		e.String("a")
+		e.String("bcd")
+		e.EndArray()
+		e.FieldBeginObject("x y")
+		e.FieldBool("z", true)
+		e.End()
+	})
+	if violations := s.Validate(valid); violations != nil {
+		t.Errorf("expected no violations, got %v", violationStrings(violations))
+	}
+
+	invalid := encode(func(e *binson.Encoder) {
+		e.FieldInt("id", 70000)
+		e.FieldString("name", "")
+		e.FieldBeginObject("pos")
+		e.FieldInt("lat", 57)
+		e.FieldBeginArray("more")
+		e.EndArray()
+		e.End()
+		e.FieldBeginArray("tags")
+		e.String("a")
+		e.Integer(1)
+		e.String("toolong")
+		e.EndArray()
+		e.FieldBool("zzz", true)
+	})
+	exp := []string{
+		"id: integer 70000 out of range 0..65535",
+		"name: length 0 out of range 1..8",
+		"pos.lat: expected double, got integer",
+		"pos.more: unexpected field",
+		"pos.lon: missing required field",
+		"tags[1]: expected string, got integer",
+		"tags[2]: length 7 out of range 0..4",
+		"tags: length 3 out of range 0..2",
+		"zzz: unexpected field",
+	}
+	if got := violationStrings(s.Validate(invalid)); !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected violations\n%s\nexpected\n%s",
+			strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+func TestValidateMissingAndOpen(t *testing.T) {
+	s, err := Parse("object { a boolean  b? bytes ..2 ... }")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj := encode(func(e *binson.Encoder) {
+		e.FieldBytes("b", []byte{1, 2, 3})
+		e.FieldInt("c", 1)
+	})
+	exp := []string{
+		"b: length 3 out of range 0..2",
+		"a: missing required field",
+	}
+	if got := violationStrings(s.Validate(obj)); !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected violations %q", got)
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	s := positionSchema(t)
+
+	table := []struct {
+		buf []byte
+		exp []string
+	}{
+		// {"pos":{"lat": <truncated>
+		{[]byte("\x40\x14\x03pos\x40\x14\x03lat\x46"), []string{"pos: malformed Binson, error code 1"}},
+		// {"id":1,...} with an extra byte after the object
+		{append(encode(func(e *binson.Encoder) {
+			e.FieldInt("id", 1)
+			e.FieldBeginObject("pos")
+			e.FieldDouble("lat", 0)
+			e.FieldDouble("lon", 0)
+			e.End()
+		}), 0x40), []string{"trailing bytes after object"}},
+		// {"id":1, "x":<bad type byte>}
+		{[]byte("\x40\x14\x02id\x10\x01\x14\x01x\xff\x41"), []string{"malformed Binson, error code 4"}},
+	}
+
+	for _, record := range table {
+		if got := violationStrings(s.Validate(record.buf)); !reflect.DeepEqual(got, record.exp) {
+			t.Errorf("%x: expected %q, got %q", record.buf, record.exp, got)
+		}
+	}
+}
+
+// The generated code in internal/example is tested there. This checks
+// that it is up to date.
+func TestGenerate(t *testing.T) {
+	text, err := os.ReadFile("internal/example/example.schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Parse(string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Generate(s, "example", "Report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := os.ReadFile("internal/example/report.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, exp) {
+		t.Errorf("internal/example/report.go is out of date, run go generate")
+	}
+}
+
+func TestGenerateNames(t *testing.T) {
+	// Field names that clash with generated methods and fields.
+	s, err := Parse(`object {
+		encode boolean  decode boolean  valid boolean  type boolean
+		a? boolean  has_a boolean  msg_writer boolean
+		o object { decode? integer  has_decode_ integer }
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Generate(s, "msg", "msg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	f, err := goparser.ParseFile(fset, "msg.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("msg", fset, []*ast.File{f}, nil); err != nil {
+		t.Errorf("generated code does not compile: %v", err)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	table := []struct {
+		text  string
+		error string
+	}{
+		{"array string", "not an object schema"},
+		{"object { a any }", "any is not supported"},
+		{"object { a array any }", "only arrays of objects"},
+		{"object { a array array boolean }", "only arrays of objects"},
+		{"object { a_b boolean  aB boolean }", "same Go name AB"},
+		{"object { a object { b any } }", "field b of MsgA"},
+		{"object { a object { b object {} }  a_b object {} }", "field b of MsgA and field a_b of Msg have the same Go name MsgABWriter"},
+		{"object { x object { a array boolean }  x_a array boolean }", "same Go name MsgXAIterator"},
+	}
+
+	for _, record := range table {
+		s, err := Parse(record.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Generate(s, "msg", "Msg")
+		if err == nil || !strings.Contains(err.Error(), record.error) {
+			t.Errorf("%q: expected error %q, got %v", record.text, record.error, err)
+		}
+	}
+}
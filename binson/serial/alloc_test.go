@@ -0,0 +1,56 @@
+// Checks that Encode and the Decoder do not allocate memory.
+// Note, like the tests of package binson, these tests are not
+// executed with "tinygo test", TinyGo has no testing.AllocsPerRun.
+
+package serial
+
+import "testing"
+
+var allocObj = newObject(300)
+
+var allocFrame = make([]byte, MaxEncodedSize(len(allocObj), CRC32))
+
+var allocBuf = make([]byte, len(allocObj)+4)
+
+func TestZeroAllocs(t *testing.T) {
+	assertNoAllocs := func(name string, f func()) {
+		if allocs := testing.AllocsPerRun(100, f); allocs != 0 {
+			t.Errorf("%s: %v allocations", name, allocs)
+		}
+	}
+
+	for _, crc := range []CRC{CRC16, CRC32} {
+		n, err := Encode(allocFrame, allocObj, crc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := allocFrame[:n]
+		assertNoAllocs("Encode", func() {
+			Encode(allocFrame, allocObj, crc)
+		})
+
+		var d Decoder
+		assertNoAllocs("Decoder", func() {
+			d.Init(allocBuf, crc)
+			if _, obj, err := d.Feed(frame); err != nil || len(obj) != len(allocObj) {
+				t.Fatalf("unexpected result %x, %v", obj, err)
+			}
+		})
+
+		// Errors are not allocated either.
+		frame[1] ^= 0xff
+		assertNoAllocs("Decoder, CRC error", func() {
+			d.Init(allocBuf, crc)
+			if _, _, err := d.Feed(frame); err != ErrCRC {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+		frame[1] ^= 0xff
+		assertNoAllocs("Decoder, short buffer", func() {
+			d.Init(allocBuf[:10], crc)
+			if _, _, err := d.Feed(frame); err != ErrShortBuffer {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+// Package serial frames Binson objects for noisy serial links, like UARTs.
+//
+// Each object gets a CRC trailer and is then COBS (Consistent Overhead
+// Byte Stuffing) encoded, which removes all zero bytes. A zero byte
+// ends each frame:
+//
+//	COBS(object | CRC, little-endian) | 0x00
+//
+// Since zero bytes only occur at frame ends, a receiver can always
+// resynchronize at the next zero byte after a corrupted frame.
+//
+// No memory is allocated by Encode or Decoder, the caller provides all
+// buffers. The package is intended for TinyGo targets.
+package serial
+
+import (
+	"errors"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// CRC selects the checksum that is appended to each object.
+type CRC int
+
+// Supported checksums.
+const (
+	CRC16 CRC = iota // CRC-16/CCITT-FALSE, 2 bytes
+	CRC32            // CRC-32 (IEEE 802.3), 4 bytes
+)
+
+// Delimiter is the byte that ends each frame.
+const Delimiter byte = 0x00
+
+// Errors returned by Encode and Decoder.Feed.
+var (
+	ErrShortBuffer = errors.New("serial: buffer too small")
+	ErrCOBS        = errors.New("serial: invalid COBS encoding")
+	ErrCRC         = errors.New("serial: CRC mismatch")
+	ErrNotObject   = errors.New("serial: frame is not a Binson object")
+)
+
+// Size returns the number of bytes of the checksum.
+func (c CRC) Size() int {
+	if c == CRC32 {
+		return 4
+	}
+	return 2
+}
+
+// Computes the checksum of b.
+func (c CRC) sum(b []byte) uint32 {
+	if c == CRC32 {
+		return crc32IEEE(b)
+	}
+	return uint32(crc16CCITT(b))
+}
+
+// MaxEncodedSize returns the max size of a frame with an object of n bytes,
+// including the delimiter.
+func MaxEncodedSize(n int, crc CRC) int {
+	n += crc.Size()
+	return n + n/254 + 1 + 1
+}
+
+// ======== Encode ========
+
+// Encode writes the Binson object in src as a frame to dst. Returns the
+// number of bytes written. dst must have room for MaxEncodedSize bytes.
+func Encode(dst, src []byte, crc CRC) (int, error) {
+	if len(dst) < MaxEncodedSize(len(src), crc) {
+		return 0, ErrShortBuffer
+	}
+
+	var trailer [4]byte
+	sum := crc.sum(src)
+	for i := 0; i < crc.Size(); i++ {
+		trailer[i] = byte(sum >> (8 * i))
+	}
+
+	w := cobsWriter{dst: dst, codeIndex: 0, offset: 1, code: 1}
+	w.write(src)
+	w.write(trailer[:crc.Size()])
+	w.finishBlock()
+	dst[w.codeIndex] = Delimiter
+
+	return w.codeIndex + 1, nil
+}
+
+// COBS encoder state. A block is a code byte followed by up to 254
+// non-zero bytes. The code is the number of bytes in the block plus
+// one; a code below 0xff means a zero byte follows the block.
+type cobsWriter struct {
+	dst       []byte
+	codeIndex int  // position of the code byte of the current block
+	offset    int  // next position to write to
+	code      byte // code of the current block
+}
+
+func (w *cobsWriter) write(b []byte) {
+	for _, c := range b {
+		if c == 0 {
+			w.finishBlock()
+			continue
+		}
+		w.dst[w.offset] = c
+		w.offset++
+		w.code++
+		if w.code == 0xff {
+			w.finishBlock()
+		}
+	}
+}
+
+// Writes the code of the current block and starts a new block.
+func (w *cobsWriter) finishBlock() {
+	w.dst[w.codeIndex] = w.code
+	w.codeIndex = w.offset
+	w.offset++
+	w.code = 1
+}
+
+// ======== Decoder ========
+
+// A Decoder decodes frames from a stream of bytes, fed to it in chunks
+// of any size. Decoded objects are written to a buffer provided by
+// the caller.
+//
+// A Decoder should be created like this:
+//
+//	d := serial.Decoder{}
+//	d.Init(buf, serial.CRC16)
+type Decoder struct {
+	buf         []byte
+	crc         CRC
+	n           int  // number of decoded bytes in buf
+	remaining   int  // bytes left in the current block
+	code        byte // code of the current block
+	pendingZero bool // a zero byte follows, unless the frame ends
+	err         error
+}
+
+// Init prepares the decoder to decode frames with checksum crc.
+// Objects of up to len(buf)-crc.Size() bytes can be decoded.
+func (d *Decoder) Init(buf []byte, crc CRC) {
+	d.buf = buf
+	d.crc = crc
+	d.reset()
+}
+
+// Feed decodes bytes from p until a frame ends. Returns the number of
+// bytes of p that were used. If a frame ended, obj is the Binson object
+// in it, or err tells why the frame is invalid. Otherwise, obj and err
+// are nil and all of p was used.
+// obj is a slice of the buffer given to Init, and is only valid until
+// the next call to Feed. Feed the rest of p to get the next frame.
+// Empty frames, such as repeated delimiters, are skipped.
+func (d *Decoder) Feed(p []byte) (n int, obj []byte, err error) {
+	for i, c := range p {
+		if c == Delimiter {
+			if d.code == 0 {
+				continue // empty frame
+			}
+			obj, err = d.finish()
+			d.reset()
+			return i + 1, obj, err
+		}
+		d.decode(c)
+	}
+	return len(p), nil, nil
+}
+
+func (d *Decoder) reset() {
+	d.n = 0
+	d.remaining = 0
+	d.code = 0
+	d.pendingZero = false
+	d.err = nil
+}
+
+// Decodes one non-delimiter byte.
+func (d *Decoder) decode(c byte) {
+	if d.err != nil {
+		return // discard until the frame ends
+	}
+
+	if d.remaining == 0 {
+		// c is a code byte, starting a new block
+		if d.pendingZero {
+			d.append(0)
+		}
+		d.code = c
+		d.remaining = int(c) - 1
+	} else {
+		d.append(c)
+		d.remaining--
+	}
+
+	if d.remaining == 0 {
+		d.pendingZero = d.code < 0xff
+	} else {
+		d.pendingZero = false
+	}
+}
+
+func (d *Decoder) append(c byte) {
+	if d.n >= len(d.buf) {
+		d.err = ErrShortBuffer
+		return
+	}
+	d.buf[d.n] = c
+	d.n++
+}
+
+// Checks the decoded frame and returns the object in it.
+func (d *Decoder) finish() ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.remaining != 0 {
+		return nil, ErrCOBS
+	}
+
+	size := d.crc.Size()
+	if d.n < size+2 {
+		return nil, ErrNotObject
+	}
+	obj := d.buf[:d.n-size]
+
+	var sum uint32
+	for i := 0; i < size; i++ {
+		sum |= uint32(d.buf[d.n-size+i]) << (8 * i)
+	}
+	if sum != d.crc.sum(obj) {
+		return nil, ErrCRC
+	}
+
+	if !binson.IsObject(obj) {
+		return nil, ErrNotObject
+	}
+	return obj, nil
+}
+
+// ======== Checksums ========
+// Bitwise implementations, no tables, to keep code size small.
+
+// CRC-16/CCITT-FALSE: polynomial 0x1021, init 0xffff, not reflected.
+func crc16CCITT(b []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// CRC-32 (IEEE 802.3): reflected polynomial 0xedb88320, init and final
+// xor 0xffffffff. Same as hash/crc32.ChecksumIEEE().
+func crc32IEEE(b []byte) uint32 {
+	crc := ^uint32(0)
+	for _, c := range b {
+		crc ^= uint32(c)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xedb88320
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
@@ -0,0 +1,171 @@
+package serial
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// {"a":0,"b":"0x000000...00"}, many zero bytes to stuff
+func newObject(size int) []byte {
+	obj := make([]byte, 0, size+20)
+	obj = append(obj, "\x40\x14\x01\x61\x10\x00\x14\x01\x62\x19"...)
+	obj = append(obj, byte(size), byte(size>>8))
+	obj = append(obj, make([]byte, size)...)
+	return append(obj, 0x41)
+}
+
+func TestCOBS(t *testing.T) {
+	// COBS examples from Cheshire and Baker, and Wikipedia.
+	table := []struct {
+		data []byte
+		exp  []byte
+	}{
+		{[]byte{0x00}, []byte{0x01, 0x01}},
+		{[]byte{0x00, 0x00}, []byte{0x01, 0x01, 0x01}},
+		{[]byte{0x11, 0x22, 0x00, 0x33}, []byte{0x03, 0x11, 0x22, 0x02, 0x33}},
+		{[]byte{0x11, 0x22, 0x33, 0x44}, []byte{0x05, 0x11, 0x22, 0x33, 0x44}},
+		{[]byte{0x11, 0x00, 0x00, 0x00}, []byte{0x02, 0x11, 0x01, 0x01, 0x01}},
+	}
+
+	for _, record := range table {
+		dst := make([]byte, 20)
+		w := cobsWriter{dst: dst, codeIndex: 0, offset: 1, code: 1}
+		w.write(record.data)
+		w.finishBlock()
+		if !bytes.Equal(record.exp, dst[:w.codeIndex]) {
+			t.Errorf("COBS failed: data %x, expected %x != got %x", record.data, record.exp, dst[:w.codeIndex])
+		}
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	check := []byte("123456789")
+	if crc16CCITT(check) != 0x29b1 {
+		t.Errorf("unexpected CRC-16/CCITT-FALSE: %x", crc16CCITT(check))
+	}
+	if crc32IEEE(check) != 0xcbf43926 {
+		t.Errorf("unexpected CRC-32: %x", crc32IEEE(check))
+	}
+	obj := newObject(1000)
+	if crc32IEEE(obj) != crc32.ChecksumIEEE(obj) {
+		t.Errorf("CRC-32 differs from hash/crc32")
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, crc := range []CRC{CRC16, CRC32} {
+		for _, size := range []int{0, 1, 240, 241, 242, 253, 254, 255, 1000} {
+			obj := newObject(size)
+			frame := make([]byte, MaxEncodedSize(len(obj), crc))
+			n, err := Encode(frame, obj, crc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.IndexByte(frame[:n-1], 0) >= 0 || frame[n-1] != Delimiter {
+				t.Fatalf("unexpected zero byte in frame")
+			}
+
+			d := Decoder{}
+			d.Init(make([]byte, len(obj)+crc.Size()), crc)
+			used, got, err := d.Feed(frame[:n])
+			if err != nil || used != n || !bytes.Equal(obj, got) {
+				t.Errorf("decode failed: crc %d, size %d: used %d, err %v", crc, size, used, err)
+			}
+		}
+	}
+}
+
+func TestEncodeShortBuffer(t *testing.T) {
+	obj := newObject(10)
+	_, err := Encode(make([]byte, len(obj)), obj, CRC16)
+	if err != ErrShortBuffer {
+		t.Errorf("expected ErrShortBuffer, got %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	obj := newObject(10)
+	frame := make([]byte, MaxEncodedSize(len(obj), CRC16))
+	n, _ := Encode(frame, obj, CRC16)
+	frame = frame[:n]
+
+	var stream []byte
+	stream = append(stream, 0x00, 0x00)       // empty frames
+	stream = append(stream, 0x33, 0x11, 0x00) // truncated block
+	corrupted := append([]byte{}, frame...)
+	corrupted[5] ^= 0x04
+	stream = append(stream, corrupted...)           // bit error
+	stream = append(stream, 0x03, 0x40, 0x41, 0x00) // no CRC
+	stream = append(stream, frame...)
+
+	d := Decoder{}
+	d.Init(make([]byte, 100), CRC16)
+	expected := []error{ErrCOBS, ErrCRC, ErrNotObject, nil}
+	for _, exp := range expected {
+		used, got, err := d.Feed(stream)
+		if err != exp {
+			t.Fatalf("expected %v, got %v", exp, err)
+		}
+		if exp == nil && !bytes.Equal(obj, got) {
+			t.Errorf("unexpected object %x", got)
+		}
+		stream = stream[used:]
+	}
+	if len(stream) != 0 {
+		t.Errorf("expected all bytes used")
+	}
+
+	d.Init(make([]byte, 10), CRC16)
+	_, _, err := d.Feed(frame)
+	if err != ErrShortBuffer {
+		t.Errorf("expected ErrShortBuffer, got %v", err)
+	}
+}
+
+// Sends frames through an in-memory pipe, read in small chunks.
+func TestPipe(t *testing.T) {
+	r, w := io.Pipe()
+	objects := [][]byte{newObject(0), newObject(300), newObject(5)}
+
+	go func() {
+		frame := make([]byte, 1000)
+		for _, obj := range objects {
+			n, _ := Encode(frame, obj, CRC32)
+			w.Write(frame[:n])
+		}
+		w.Close()
+	}()
+
+	d := Decoder{}
+	d.Init(make([]byte, 1000), CRC32)
+	chunk := make([]byte, 7)
+	var got [][]byte
+	for {
+		m, err := r.Read(chunk)
+		p := chunk[:m]
+		for len(p) > 0 {
+			used, obj, ferr := d.Feed(p)
+			if ferr != nil {
+				t.Fatal(ferr)
+			}
+			if obj != nil {
+				got = append(got, append([]byte{}, obj...))
+			}
+			p = p[used:]
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if len(got) != len(objects) {
+		t.Fatalf("expected %d objects, got %d", len(objects), len(got))
+	}
+	for i := range objects {
+		if !bytes.Equal(objects[i], got[i]) {
+			t.Errorf("object %d differs", i)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package sign signs and verifies Binson objects with Ed25519.
+//
+// The signature is computed over the exact bytes of a canonical Binson
+// object (see binson.Validate) and is stored as a bytes value in the
+// field named Field, at its sorted position among the other fields.
+// Sign rewrites its input into canonical form first (see
+// binson.Canonicalize). Verification removes the field, which gives
+// back the exact bytes that were signed.
+//
+// Sign allocates a buffer the size of its input for the canonical form,
+// and Verify one the size of the signed object for the unsigned bytes.
+package sign
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// Field is the name of the field that holds the signature.
+const Field = "signature"
+
+// SignatureOverhead is the number of bytes the signature field adds
+// to a signed object.
+const SignatureOverhead = 2 + len(Field) + 2 + ed25519.SignatureSize
+
+// Errors returned by Sign and Verify.
+var (
+	ErrNotCanonical = errors.New("sign: not a canonical Binson object")
+	ErrSigned       = errors.New("sign: object already has a signature field")
+	ErrNotSigned    = errors.New("sign: object has no signature field")
+	ErrSignature    = errors.New("sign: invalid signature")
+	ErrShortBuffer  = errors.New("sign: output buffer too small")
+)
+
+// Sign signs the Binson object in src with key and writes the canonical
+// form of the object, with the signature field added, to dst. Returns
+// the number of bytes written to dst. dst must have room for
+// len(src) + SignatureOverhead bytes, and must not overlap src.
+// Objects that can not be made canonical, for example because of
+// duplicate field names, give ErrNotCanonical.
+func Sign(dst, src []byte, key ed25519.PrivateKey) (int, error) {
+	canonical := make([]byte, len(src))
+	n, code := binson.Canonicalize(canonical, src)
+	if code != binson.ErrorNone {
+		return 0, fmt.Errorf("%w (binson error %d)", ErrNotCanonical, code)
+	}
+	src = canonical[:n]
+
+	var d binson.Decoder
+	d.Init(src)
+	if d.Field(Field) {
+		return 0, ErrSigned
+	}
+
+	sig := ed25519.Sign(key, src)
+
+	d.Init(src)
+	var e binson.Encoder
+	e.Init(dst)
+	e.Begin()
+	written := false
+	for d.NextField() {
+		if !written && string(d.Name) > Field {
+			e.FieldBytes(Field, sig)
+			written = true
+		}
+		e.NameBytes(d.Name)
+		copyValue(&e, &d, false)
+	}
+	if !written {
+		e.FieldBytes(Field, sig)
+	}
+	e.End()
+
+	if d.Error != binson.ErrorNone {
+		return 0, fmt.Errorf("sign: binson error %d", d.Error)
+	}
+	if e.Error != binson.ErrorNone {
+		return 0, ErrShortBuffer
+	}
+	return e.Offset, nil
+}
+
+// Verify checks the signature of the signed Binson object in buf
+// against the public key pub. Returns nil if the signature is valid.
+func Verify(buf []byte, pub ed25519.PublicKey) error {
+	if err := validate(buf); err != nil {
+		return err
+	}
+
+	var d binson.Decoder
+	d.Init(buf)
+	if !d.Field(Field) {
+		return ErrNotSigned
+	}
+	if d.ValueType != binson.Bytes || len(d.ValueBytes) != ed25519.SignatureSize {
+		return ErrSignature
+	}
+	sig := d.ValueBytes
+
+	// The signed bytes are the object without the signature field.
+	unsigned := make([]byte, len(buf))
+	d.Init(buf)
+	var e binson.Encoder
+	e.Init(unsigned)
+	e.Begin()
+	for d.NextField() {
+		if string(d.Name) == Field {
+			continue
+		}
+		e.NameBytes(d.Name)
+		copyValue(&e, &d, false)
+	}
+	e.End()
+
+	if d.Error != binson.ErrorNone {
+		return fmt.Errorf("sign: binson error %d", d.Error)
+	}
+	if e.Error != binson.ErrorNone {
+		return fmt.Errorf("sign: binson error %d", e.Error)
+	}
+	if !ed25519.Verify(pub, unsigned[:e.Offset], sig) {
+		return ErrSignature
+	}
+	return nil
+}
+
+func validate(buf []byte) error {
+	if code := binson.Validate(buf); code != binson.ErrorNone {
+		return fmt.Errorf("%w (binson error %d)", ErrNotCanonical, code)
+	}
+	return nil
+}
+
+// Writes the last value read by d to e. Objects and arrays are copied
+// recursively, d is then moved up to the parent, which is an array
+// if inArray is true. Since the input is canonical, the copy has the
+// same bytes as the input.
+func copyValue(e *binson.Encoder, d *binson.Decoder, inArray bool) {
+	switch d.ValueType {
+	case binson.Boolean:
+		e.Bool(d.ValueBoolean)
+	case binson.Integer:
+		e.Integer(d.ValueInteger)
+	case binson.Double:
+		e.Double(d.ValueDouble)
+	case binson.String:
+		e.String(string(d.ValueBytes))
+	case binson.Bytes:
+		e.Bytes(d.ValueBytes)
+	case binson.Object:
+		e.Begin()
+		d.GoIntoObject()
+		for d.NextField() {
+			e.NameBytes(d.Name)
+			copyValue(e, d, false)
+		}
+		goUp(d, inArray)
+		e.End()
+	case binson.Array:
+		e.BeginArray()
+		d.GoIntoArray()
+		for d.NextArrayValue() {
+			copyValue(e, d, true)
+		}
+		goUp(d, inArray)
+		e.EndArray()
+	}
+}
+
+func goUp(d *binson.Decoder, inArray bool) {
+	if inArray {
+		d.GoUpToArray()
+	} else {
+		d.GoUpToObject()
+	}
+}
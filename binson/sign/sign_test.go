@@ -0,0 +1,157 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+var seed = []byte("0123456789abcdef0123456789abcdef")
+
+// {"a":1,"b":{"c":[true,{"d":"x"}]},"z":"zz"}
+func newMessage(t *testing.T) []byte {
+	buf := make([]byte, 100)
+	e := binson.Encoder{}
+	e.Init(buf)
+	e.Begin()
+	e.FieldInt("a", 1)
+	e.FieldBeginObject("b")
+	e.FieldBeginArray("c")
+	e.Bool(true)
+	e.Begin()
+	e.FieldString("d", "x")
+	e.End()
+	e.EndArray()
+	e.End()
+	e.FieldString("z", "zz")
+	e.End()
+	if e.Error != binson.ErrorNone {
+		t.Fatalf("encoder error %d", e.Error)
+	}
+	return buf[:e.Offset]
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(seed)
+	msg := newMessage(t)
+
+	signed := make([]byte, len(msg)+SignatureOverhead)
+	n, err := Sign(signed, msg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(signed) {
+		t.Errorf("expected %d bytes, got %d", len(signed), n)
+	}
+	if code := binson.Validate(signed); code != binson.ErrorNone {
+		t.Errorf("signed object not canonical, error %d", code)
+	}
+
+	var d binson.Decoder
+	d.Init(signed)
+	if !d.Field(Field) || !bytes.Equal(ed25519.Sign(key, msg), d.ValueBytes) {
+		t.Errorf("unexpected signature field")
+	}
+
+	if err := Verify(signed, key.Public().(ed25519.PublicKey)); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+}
+
+func TestSignFieldLast(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(seed)
+	msg := []byte("\x40\x14\x01\x61\x10\x01\x41") // {"a":1}
+
+	signed := make([]byte, 200)
+	n, err := Sign(signed, msg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(signed[:n], key.Public().(ed25519.PublicKey)); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+}
+
+func TestSignCanonicalizes(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(seed)
+	// {"b":1,"a":2}, unsorted, with 2 as a 2-byte integer
+	msg := []byte("\x40\x14\x01\x62\x10\x01\x14\x01\x61\x11\x02\x00\x41")
+	// {"a":2,"b":1}
+	canonical := []byte("\x40\x14\x01\x61\x10\x02\x14\x01\x62\x10\x01\x41")
+
+	signed := make([]byte, len(msg)+SignatureOverhead)
+	n, err := Sign(signed, msg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(canonical)+SignatureOverhead {
+		t.Errorf("expected %d bytes, got %d", len(canonical)+SignatureOverhead, n)
+	}
+
+	var d binson.Decoder
+	d.Init(signed[:n])
+	if !d.Field(Field) || !bytes.Equal(ed25519.Sign(key, canonical), d.ValueBytes) {
+		t.Errorf("signature is not over the canonical form")
+	}
+	if err := Verify(signed[:n], key.Public().(ed25519.PublicKey)); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(seed)
+	msg := newMessage(t)
+
+	signed := make([]byte, len(msg)+SignatureOverhead)
+	n, err := Sign(signed, msg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed[n-2] = 'y' // "zz" -> "zy"
+	err = Verify(signed, key.Public().(ed25519.PublicKey))
+	if !errors.Is(err, ErrSignature) {
+		t.Errorf("expected ErrSignature, got %v", err)
+	}
+
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	signed[n-2] = 'z'
+	err = Verify(signed, other.Public().(ed25519.PublicKey))
+	if !errors.Is(err, ErrSignature) {
+		t.Errorf("expected ErrSignature, got %v", err)
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(seed)
+	buf := make([]byte, 200)
+
+	// {"a":1,"a":2}
+	_, err := Sign(buf, []byte("\x40\x14\x01\x61\x10\x01\x14\x01\x61\x10\x02\x41"), key)
+	if !errors.Is(err, ErrNotCanonical) {
+		t.Errorf("expected ErrNotCanonical, got %v", err)
+	}
+
+	msg := newMessage(t)
+	_, err = Sign(buf[:len(msg)], msg, key)
+	if !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("expected ErrShortBuffer, got %v", err)
+	}
+
+	n, err := Sign(buf, msg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Sign(make([]byte, 300), buf[:n], key)
+	if !errors.Is(err, ErrSigned) {
+		t.Errorf("expected ErrSigned, got %v", err)
+	}
+
+	err = Verify(msg, key.Public().(ed25519.PublicKey))
+	if !errors.Is(err, ErrNotSigned) {
+		t.Errorf("expected ErrNotSigned, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+// Package text converts Binson objects to and from a human-readable
+// text notation, for test vectors, logs and command line tools.
+//
+// The notation is like JSON, with these differences:
+//
+//	0x01ff          bytes, in hex, 0x is an empty bytes value
+//	12, -3          integers have no decimal point or exponent
+//	1.0, 1e3        doubles always have a decimal point or exponent
+//	NaN, +Inf, -Inf special doubles
+//
+// Strings and field names are quoted as Go string literals, which
+// are a superset of JSON strings. Example:
+//
+//	{"id": 123, "pos": {"lat": 57.7, "lon": 11.9}, "raw": 0x00ff, "tags": ["a", "b"]}
+//
+// Fields are written by Parse in the order of the text, unsorted input
+// gives unsorted Binson objects. Format writes the fields in the order
+// of the Binson object.
+//
+// Format and FormatValue build a new string. Parse writes to the given
+// encoder, but allocates the unquoted strings and decoded bytes values.
+package text
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+// ======== Format ========
+
+// Format returns the Binson object in buf in text notation. If buf is
+// not a valid Binson object, the text up to the error is returned,
+// followed by "<error N>", with the binson error code N.
+func Format(buf []byte) string {
+	var b strings.Builder
+	var d binson.Decoder
+	d.Init(buf)
+
+	writeFields(&b, &d)
+	if d.Error == binson.ErrorNone && d.Offset() != len(buf) {
+		d.Error = binson.ErrorTrailingBytes
+	}
+	if d.Error != binson.ErrorNone {
+		b.WriteString("<error " + strconv.Itoa(d.Error) + ">")
+	}
+	return b.String()
+}
+
+// FormatValue returns the last value read by d in text notation, like
+// Format does for field values. For objects and arrays, d is left at
+// the end of the value and must be moved up to the parent. If d fails,
+// the text up to the error is returned, followed by "<error N>".
+func FormatValue(d *binson.Decoder) string {
+	var b strings.Builder
+	if !writeValue(&b, d) {
+		b.WriteString("<error " + strconv.Itoa(d.Error) + ">")
+	}
+	return b.String()
+}
+
+// Writes the fields of the object that d is in, with braces.
+func writeFields(b *strings.Builder, d *binson.Decoder) {
+	b.WriteByte('{')
+	for i := 0; d.NextField(); i++ {
+		if d.Error != binson.ErrorNone {
+			return
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.Quote(string(d.Name)))
+		b.WriteString(": ")
+		container := isContainer(d.ValueType)
+		if !writeValue(b, d) {
+			return
+		}
+		if container {
+			d.GoUpToObject()
+		}
+	}
+	if d.Error == binson.ErrorNone {
+		b.WriteByte('}')
+	}
+}
+
+// Writes the last value read by d. Returns false on errors. For objects
+// and arrays, d is left at the end of the value and must be moved up
+// to the parent.
+func writeValue(b *strings.Builder, d *binson.Decoder) bool {
+	switch d.ValueType {
+	case binson.Boolean:
+		b.WriteString(strconv.FormatBool(d.ValueBoolean))
+	case binson.Integer:
+		b.WriteString(strconv.FormatInt(d.ValueInteger, 10))
+	case binson.Double:
+		b.WriteString(formatDouble(d.ValueDouble))
+	case binson.String:
+		b.WriteString(strconv.Quote(string(d.ValueBytes)))
+	case binson.Bytes:
+		b.WriteString("0x")
+		b.WriteString(hex.EncodeToString(d.ValueBytes))
+	case binson.Object:
+		d.GoIntoObject()
+		writeFields(b, d)
+	case binson.Array:
+		b.WriteByte('[')
+		d.GoIntoArray()
+		for i := 0; d.NextArrayValue(); i++ {
+			if d.Error != binson.ErrorNone {
+				return false
+			}
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			container := isContainer(d.ValueType)
+			if !writeValue(b, d) {
+				return false
+			}
+			if container {
+				d.GoUpToArray()
+			}
+		}
+		if d.Error != binson.ErrorNone {
+			return false
+		}
+		b.WriteByte(']')
+	}
+	return d.Error == binson.ErrorNone
+}
+
+func isContainer(t binson.ValueType) bool {
+	return t == binson.Object || t == binson.Array
+}
+
+// Formats a double so that it is not read back as an integer.
+func formatDouble(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// ======== Parse ========
+
+// Parse parses one object in text notation and writes it to e.
+// Returns an error if the text is invalid, or if e failed, for example
+// when its buffer is too small. Strings and names must be valid UTF-8.
+func Parse(text string, e *binson.Encoder) error {
+	p := parser{text: text, e: e}
+	p.skipSpace()
+	if !p.consume('{') {
+		return p.errorf("expected {")
+	}
+	if err := p.object(0); err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.pos != len(p.text) {
+		return p.errorf("unexpected text after object")
+	}
+	if e.Error != binson.ErrorNone {
+		return fmt.Errorf("text: encoder error %d", e.Error)
+	}
+	return nil
+}
+
+// maxDepth is the max nesting of objects and arrays, as in the
+// binson package.
+const maxDepth = 100
+
+type parser struct {
+	text string
+	pos  int
+	e    *binson.Encoder
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("text: offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.text) && strings.IndexByte(" \t\r\n", p.text[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// Skips white space, and c if it is next. Returns true if c was found.
+func (p *parser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.text) && p.text[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// Parses the fields of an object and the closing brace. The opening
+// brace has been read.
+func (p *parser) object(depth int) error {
+	if depth >= maxDepth {
+		return p.errorf("too deep")
+	}
+	p.e.Begin()
+	if p.consume('}') {
+		p.e.End()
+		return nil
+	}
+	for {
+		p.skipSpace()
+		name, err := p.string()
+		if err != nil {
+			return err
+		}
+		p.e.Name(name)
+		if !p.consume(':') {
+			return p.errorf("expected :")
+		}
+		if err := p.value(depth); err != nil {
+			return err
+		}
+		if p.consume('}') {
+			p.e.End()
+			return nil
+		}
+		if !p.consume(',') {
+			return p.errorf("expected , or }")
+		}
+	}
+}
+
+// Parses the values of an array and the closing bracket. The opening
+// bracket has been read.
+func (p *parser) array(depth int) error {
+	if depth >= maxDepth {
+		return p.errorf("too deep")
+	}
+	p.e.BeginArray()
+	if p.consume(']') {
+		p.e.EndArray()
+		return nil
+	}
+	for {
+		if err := p.value(depth); err != nil {
+			return err
+		}
+		if p.consume(']') {
+			p.e.EndArray()
+			return nil
+		}
+		if !p.consume(',') {
+			return p.errorf("expected , or ]")
+		}
+	}
+}
+
+func (p *parser) value(depth int) error {
+	p.skipSpace()
+	if p.pos == len(p.text) {
+		return p.errorf("expected value")
+	}
+
+	switch p.text[p.pos] {
+	case '{':
+		p.pos++
+		return p.object(depth + 1)
+	case '[':
+		p.pos++
+		return p.array(depth + 1)
+	case '"':
+		s, err := p.string()
+		if err != nil {
+			return err
+		}
+		p.e.String(s)
+		return nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.text) && isWordByte(p.text[p.pos]) {
+		p.pos++
+	}
+	word := p.text[start:p.pos]
+
+	switch {
+	case word == "true" || word == "false":
+		p.e.Bool(word == "true")
+	case strings.HasPrefix(word, "0x"):
+		b, err := hex.DecodeString(word[2:])
+		if err != nil {
+			p.pos = start
+			return p.errorf("invalid bytes %s", word)
+		}
+		p.e.Bytes(b)
+	case word == "NaN" || strings.HasSuffix(word, "Inf") || strings.ContainsAny(word, ".eE"):
+		f, err := strconv.ParseFloat(word, 64)
+		if err != nil && !isRangeError(err) {
+			p.pos = start
+			return p.errorf("invalid value %q", word)
+		}
+		p.e.Double(f)
+	default:
+		i, err := strconv.ParseInt(word, 10, 64)
+		if err != nil {
+			p.pos = start
+			if word == "" {
+				return p.errorf("expected value")
+			}
+			return p.errorf("invalid value %q", word)
+		}
+		p.e.Integer(i)
+	}
+	return nil
+}
+
+// Parses a quoted string.
+func (p *parser) string() (string, error) {
+	start := p.pos
+	if !p.consume('"') {
+		return "", p.errorf("expected string")
+	}
+	for p.pos < len(p.text) && p.text[p.pos] != '"' {
+		if p.text[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.text) {
+		p.pos = start
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+
+	quoted := p.text[start:p.pos]
+	s, err := strconv.Unquote(quoted)
+	if err != nil {
+		p.pos = start
+		return "", p.errorf("invalid string %s", quoted)
+	}
+	return s, nil
+}
+
+func isWordByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c == '+' || c == '-' || c == '.'
+}
+
+// Values like 1e400 are parsed as infinity, as in Go.
+func isRangeError(err error) bool {
+	numErr, ok := err.(*strconv.NumError)
+	return ok && numErr.Err == strconv.ErrRange
+}
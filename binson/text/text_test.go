@@ -0,0 +1,146 @@
+package text
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+)
+
+func parse(t *testing.T, text string) []byte {
+	buf := make([]byte, 1000)
+	var e binson.Encoder
+	e.Init(buf)
+	if err := Parse(text, &e); err != nil {
+		t.Fatalf("%s: %v", text, err)
+	}
+	return buf[:e.Offset]
+}
+
+// Text and Binson pairs. The text is in the form written by Format.
+var textTable = []struct {
+	text string
+	raw  []byte
+}{
+	{`{}`, []byte("\x40\x41")},
+	{`{"a": 123}`, []byte("\x40\x14\x01a\x10\x7b\x41")},
+	{`{"a": -1, "b": 1.0}`, []byte("\x40\x14\x01a\x10\xff\x14\x01b\x46\x00\x00\x00\x00\x00\x00\xf0\x3f\x41")},
+	{`{"": true, "a": false}`, []byte("\x40\x14\x00\x44\x14\x01a\x45\x41")},
+	{`{"b": 0x, "c": 0x01ff}`, []byte("\x40\x14\x01b\x18\x00\x14\x01c\x18\x02\x01\xff\x41")},
+	{`{"s": "a\"\nå"}`, []byte("\x40\x14\x01s\x14\x05a\"\n\xc3\xa5\x41")},
+	{`{"a": [], "b": [{}, [1, "x"]], "c": {"d": {}}}`,
+		[]byte("\x40\x14\x01a\x42\x43\x14\x01b\x42\x40\x41\x42\x10\x01\x14\x01x\x43\x43" +
+			"\x14\x01c\x40\x14\x01d\x40\x41\x41\x41")},
+	{`{"n": NaN, "p": +Inf, "q": -Inf, "r": 1e+21, "s": -0.5}`, nil},
+	// Fields are not sorted by Parse.
+	{`{"b": 1, "a": 2}`, []byte("\x40\x14\x01b\x10\x01\x14\x01a\x10\x02\x41")},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for i, record := range textTable {
+		raw := parse(t, record.text)
+		if record.raw != nil && !bytes.Equal(raw, record.raw) {
+			t.Errorf("record %d: expected 0x%s, got 0x%s", i,
+				hex.EncodeToString(record.raw), hex.EncodeToString(raw))
+		}
+		if text := Format(raw); text != record.text {
+			t.Errorf("record %d: expected %s, got %s", i, record.text, text)
+		}
+	}
+}
+
+func TestParseNotation(t *testing.T) {
+	table := []struct {
+		text, formatted string
+	}{
+		// White space, exponents and Inf without sign.
+		{" {\n\t\"a\" :1e3 ,\"b\":[ ]\r\n} ", `{"a": 1000.0, "b": []}`},
+		{`{"a": Inf, "b": 2.50, "c": +7, "d": 1e400}`, `{"a": +Inf, "b": 2.5, "c": 7, "d": +Inf}`},
+		{`{"a": 0xABcd}`, `{"a": 0xabcd}`},
+		{`{"a": "\x41"}`, `{"a": "A"}`},
+	}
+
+	for _, record := range table {
+		if text := Format(parse(t, record.text)); text != record.formatted {
+			t.Errorf("%q: expected %s, got %s", record.text, record.formatted, text)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	table := []struct {
+		text  string
+		error string
+	}{
+		{``, "offset 0: expected {"},
+		{`[]`, "offset 0: expected {"},
+		{`{"a" 1}`, "offset 5: expected :"},
+		{`{"a": 1 "b": 2}`, "offset 8: expected , or }"},
+		{`{"a": [1 2]}`, "offset 9: expected , or ]"},
+		{`{"a": 1,}`, "offset 8: expected string"},
+		{`{a: 1}`, "offset 1: expected string"},
+		{`{"a": }`, "offset 6: expected value"},
+		{`{"a": 0x123}`, "offset 6: invalid bytes 0x123"},
+		{`{"a": 1.2.3}`, `offset 6: invalid value "1.2.3"`},
+		{`{"a": yes}`, `offset 6: invalid value "yes"`},
+		{`{"a": 99999999999999999999}`, `invalid value "99999999999999999999"`},
+		{`{"a": "x}`, "offset 6: unterminated string"},
+		{`{"a": "\q"}`, `offset 6: invalid string "\q"`},
+		{`{"a": 1} {}`, "offset 9: unexpected text after object"},
+		{`{"a": "\xff"}`, "encoder error 17"},
+		{`{"a": ` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `}`, "too deep"},
+	}
+
+	for _, record := range table {
+		var e binson.Encoder
+		e.Init(make([]byte, 1000))
+		err := Parse(record.text, &e)
+		if err == nil || !strings.Contains(err.Error(), record.error) {
+			t.Errorf("%q: expected error %q, got %v", record.text, record.error, err)
+		}
+	}
+}
+
+func TestParseShortBuffer(t *testing.T) {
+	var e binson.Encoder
+	e.Init(make([]byte, 5))
+	err := Parse(`{"a": "bcd"}`, &e)
+	if err == nil || !strings.Contains(err.Error(), "encoder error 1") {
+		t.Errorf("expected encoder error, got %v", err)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	table := []struct {
+		raw  []byte
+		text string
+	}{
+		{[]byte("\x40\x14\x01a\x10\x01"), `{"a": 1<error 1>`},
+		{[]byte("\x40\x14\x01a\x42\x10\x01\xff"), `{"a": [1<error 4>`},
+		{[]byte("\x40\x41\x40"), `{}<error 22>`},
+		{[]byte("\x42\x43"), `{<error 13>`},
+	}
+
+	for _, record := range table {
+		if text := Format(record.raw); text != record.text {
+			t.Errorf("0x%s: expected %s, got %s", hex.EncodeToString(record.raw), record.text, text)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	raw := parse(t, `{"a": {"b": [1, 0x01]}, "c": "x"}`)
+	var d binson.Decoder
+	d.Init(raw)
+	d.NextField()
+	if s := FormatValue(&d); s != `{"b": [1, 0x01]}` {
+		t.Errorf("unexpected text %s", s)
+	}
+	d.GoUpToObject()
+	d.NextField()
+	if s := FormatValue(&d); s != `"x"` {
+		t.Errorf("unexpected text %s", s)
+	}
+}
@@ -0,0 +1,130 @@
+// Command binson is a tool for working with Binson files.
+//
+// Usage:
+//
+//	binson diff old.bin new.bin
+//	binson text file.bin
+//	binson parse file.txt > file.bin
+//
+// The diff command prints the changes from the Binson object in old.bin
+// to the one in new.bin, one per line. The exit status is 0 if the
+// objects are equal, 1 if they differ and 2 on errors.
+//
+// The text command prints a Binson object in the text notation of
+// package text, and the parse command converts the text notation back
+// to Binson, written to standard output. If the file is not a valid
+// Binson object, the text command prints the text up to the error and
+// exits with status 2.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson"
+	"github.com/assaabloy-ppi/binson-go-tiny/binson/diff"
+	"github.com/assaabloy-ppi/binson-go-tiny/binson/text"
+)
+
+const usage = `usage: binson command [arguments]
+
+commands:
+  diff old.bin new.bin   print the changes between two objects
+  text file.bin          print an object in text notation
+  parse file.txt         convert text notation to Binson on stdout
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var status int
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "diff":
+		status, err = diffCommand(args)
+	case "text":
+		err = textCommand(args)
+	case "parse":
+		err = parseCommand(args)
+	default:
+		err = fmt.Errorf("unknown command %q\n\n%s", cmd, usage)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "binson:", err)
+		os.Exit(2)
+	}
+	os.Exit(status)
+}
+
+func diffCommand(args []string) (int, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("diff needs two files\n\n%s", usage)
+	}
+	old, err := os.ReadFile(args[0])
+	if err != nil {
+		return 0, err
+	}
+	new, err := os.ReadFile(args[1])
+	if err != nil {
+		return 0, err
+	}
+
+	changes, err := diff.Diff(old, new)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(changes) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func textCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("text needs one file\n\n%s", usage)
+	}
+	buf, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(text.Format(buf))
+	if !binson.IsObject(buf) {
+		return fmt.Errorf("%s is not a valid Binson object", args[0])
+	}
+	return nil
+}
+
+func parseCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("parse needs one file\n\n%s", usage)
+	}
+	src, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Doubles take 9 bytes, so the output can be larger than the text.
+	// Retry with a larger buffer until it fits.
+	buf := make([]byte, 2*len(src)+64)
+	for {
+		var e binson.Encoder
+		e.Init(buf)
+		err = text.Parse(string(src), &e)
+		if e.Error == binson.ErrorEOF {
+			buf = make([]byte, 2*len(buf))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(buf[:e.Offset])
+		return err
+	}
+}
@@ -0,0 +1,75 @@
+// Command binsongen generates typed Go writers and readers for Binson
+// messages described by a schema, see package schema.
+//
+// Usage:
+//
+//	binsongen -type Msg -package msg -o msg.go msg.schema
+//
+// The schema is read as a text description, or as a Binson description
+// if the file name ends with ".binson".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/assaabloy-ppi/binson-go-tiny/binson/schema"
+)
+
+func main() {
+	typeName := flag.String("type", "Msg", "name of the message type")
+	pkg := flag.String("package", "", "package name, default is the name of the output directory")
+	out := flag.String("o", "", "output file, default is standard output")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: binsongen [flags] schema-file\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *typeName, *pkg, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "binsongen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(schemaFile, typeName, pkg, out string) error {
+	desc, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return err
+	}
+
+	var s *schema.Value
+	if strings.HasSuffix(schemaFile, ".binson") {
+		s, err = schema.ParseBinson(desc)
+	} else {
+		s, err = schema.Parse(string(desc))
+	}
+	if err != nil {
+		return err
+	}
+
+	if pkg == "" {
+		dir, err := filepath.Abs(filepath.Dir(out))
+		if err != nil {
+			return err
+		}
+		pkg = filepath.Base(dir)
+	}
+
+	src, err := schema.Generate(s, pkg, typeName)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0644)
+}